@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Check the local files are synced into the bucket, it exits with 1 on the first discrepancy. Nothing will be changed.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The project root is required for walking the local files.
+			config, err := ReadValidConfig(true)
+			if err != nil {
+				return err
+			}
+			if err := setupLogging(config); err != nil {
+				return err
+			}
+			if err := loadExcludePatterns(config.ProjectRoot); err != nil {
+				return exitErrorf(ExitFailure, "Failed to load the exclude patterns.\nError: %v", err)
+			}
+			client, err := newBucketClient(config)
+			if err != nil {
+				return err
+			}
+
+			discrepancy, err := CheckSync(client, config)
+			if err != nil {
+				return err
+			}
+			if checkJSON {
+				out, _ := json.Marshal(checkReport{InSync: discrepancy == nil, Discrepancy: discrepancy})
+				fmt.Println(string(out))
+			}
+			if discrepancy != nil {
+				return exitErrorf(ExitFailure, "The bucket is out of sync: %s", discrepancy)
+			}
+			successf("All the files are synced")
+			return nil
+		},
+	}
+
+	checkJSON          = false
+	checkIgnoreOrphans = false
+)
+
+func init() {
+	checkCmd.Flags().BoolVarP(&checkJSON, "json", "", false, "Print the check result in JSON to stdout")
+	checkCmd.Flags().BoolVarP(&checkIgnoreOrphans, "ignore-orphans", "", false, "Only check the missing uploads, the remote objects without the local files are ignored")
+	rootCmd.AddCommand(checkCmd)
+}
+
+// Discrepancy is a difference between the local files and the bucket, the type is one of upload,
+// overwrite and orphan. The orphan is the remote object without the local file.
+type Discrepancy struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	File string `json:"file,omitempty"`
+}
+
+func (d *Discrepancy) String() string {
+	if d.File == "" {
+		return fmt.Sprintf("%s [%s]", d.Type, d.Key)
+	}
+	return fmt.Sprintf("%s [%s] from [%s]", d.Type, d.Key, d.File)
+}
+
+type checkReport struct {
+	InSync      bool         `json:"inSync"`
+	Discrepancy *Discrepancy `json:"discrepancy,omitempty"`
+}
+
+// CheckSync compares the local files in the synced directories with the listed objects by the sizes and
+// the ETags, like the sync does. It returns the first discrepancy in the key order or nil if they are in sync.
+func CheckSync(client *BucketClient, config *PandoraConfig) (*Discrepancy, error) {
+	directories := []string{"images", "uploads"}
+	files, err := listSyncFiles(config.ProjectRoot, directories)
+	if err != nil {
+		return nil, exitErrorf(ExitFailure, "Failed to read the local files.\nError: %v", err)
+	}
+	locals := map[string]string{}
+	for _, name := range files {
+		_, key, _ := syncKey(client, config.ProjectRoot, name, nil, time.Time{})
+		locals[key] = name
+	}
+
+	// The routed and the prefix mapped objects are placed outside the synced directories. The prefixes end with
+	// the slash, the objects of the sibling directories like images-old are never listed.
+	prefixes := map[string]struct{}{}
+	for _, directory := range directories {
+		prefixes[client.RemoteKey(directory)+"/"] = struct{}{}
+		for local := range client.Prefixes {
+			if strings.HasPrefix(local, directory+"/") {
+				prefixes[client.RemoteKey(local)+"/"] = struct{}{}
+			}
+		}
+	}
+	for _, prefix := range client.Routes {
+		prefixes[prefix+"/"] = struct{}{}
+	}
+	remotes := map[string]RemoteObject{}
+	for prefix := range prefixes {
+		objs, err := client.ListObjects(operationContext, prefix)
+		if isAuthError(err) {
+			return nil, exitErrorf(ExitAuth, "The S3 credentials are rejected.\nError: %v", err)
+		} else if err != nil {
+			return nil, exitErrorf(ExitFailure, "Failed to list the objects in %s.\nError: %v", prefix, err)
+		}
+		for _, obj := range objs {
+			remotes[*obj.Key] = newRemoteObject(obj)
+		}
+	}
+
+	for _, key := range sortedKeys(locals) {
+		remote, ok := remotes[key]
+		if !ok {
+			return &Discrepancy{Type: "upload", Key: key, File: locals[key]}, nil
+		}
+		content, err := os.ReadFile(locals[key])
+		if err != nil {
+			return nil, exitErrorf(ExitFailure, "Failed to read the file %s.\nError: %v", locals[key], err)
+		}
+		if remote.Changed(content) {
+			return &Discrepancy{Type: "overwrite", Key: key, File: locals[key]}, nil
+		}
+	}
+	if checkIgnoreOrphans {
+		return nil, nil
+	}
+	orphans := make([]string, 0, len(remotes))
+	for key := range remotes {
+		// The objects of the excluded files aren't orphans, the key is matched as it's relative to the project root.
+		if _, ok := locals[key]; !ok && !isGeneratedKey(key, config) && !isExcludedKey(key) {
+			orphans = append(orphans, key)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil, nil
+	}
+	sort.Strings(orphans)
+	return &Discrepancy{Type: "orphan", Key: orphans[0]}, nil
+}
+
+// isGeneratedKey checks the object is generated by the sync instead of uploaded from a local file.
+func isGeneratedKey(key string, config *PandoraConfig) bool {
+	return key == ImageMetadataFile || strings.HasPrefix(key, "images/metadata/") || strings.HasSuffix(key, "/") ||
+		config.Sync.HeadersFile != "" && key == strings.TrimPrefix(config.Sync.HeadersFile, "/") ||
+		config.Sync.DirectoryIndex != "" && path.Base(key) == "index."+config.Sync.DirectoryIndex
+}
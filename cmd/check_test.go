@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// listObjectsServer serves the ListObjectsV2 of the objects by their keys and contents.
+func listObjectsServer(objects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		b.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>pandora</Name><IsTruncated>false</IsTruncated>`)
+		for _, key := range sortedKeys(objects) {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				sum := md5.Sum([]byte(objects[key]))
+				_, _ = fmt.Fprintf(&b, `<Contents><Key>%s</Key><Size>%d</Size><ETag>"%s"</ETag></Contents>`, key, len(objects[key]), hex.EncodeToString(sum[:]))
+			}
+		}
+		b.WriteString(`</ListBucketResult>`)
+		_, _ = w.Write([]byte(b.String()))
+	}))
+}
+
+func TestCheckSync(t *testing.T) {
+	root := t.TempDir()
+	locals := map[string]string{"images/a.jpg": "cat", "images/drafts/b.jpg": "dog"}
+	for name, content := range locals {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		objects  map[string]string
+		exclude  []string
+		prefixes map[string]string
+		want     string
+	}{
+		{"in sync", map[string]string{"images/a.jpg": "cat", "images/drafts/b.jpg": "dog"}, nil, nil, ""},
+		{"missing upload", map[string]string{"images/a.jpg": "cat"}, nil, nil, "upload [images/drafts/b.jpg]"},
+		{"same size with another content", map[string]string{"images/a.jpg": "cow", "images/drafts/b.jpg": "dog"}, nil, nil, "overwrite [images/a.jpg]"},
+		{"orphan", map[string]string{"images/a.jpg": "cat", "images/drafts/b.jpg": "dog", "images/c.jpg": "pig"}, nil, nil, "orphan [images/c.jpg]"},
+		{"excluded", map[string]string{"images/a.jpg": "cat", "images/drafts/old.jpg": "owl"}, []string{"images/drafts/**"}, nil, ""},
+		{"sibling directory", map[string]string{"images/a.jpg": "cat", "images/drafts/b.jpg": "dog", "images-old/c.jpg": "pig"}, nil, nil, ""},
+		{"prefix mapped", map[string]string{"images/a.jpg": "cat", "drafts/b.jpg": "dog"}, nil, map[string]string{"images/drafts": "drafts"}, ""},
+		{"prefix mapped orphan", map[string]string{"images/a.jpg": "cat", "drafts/b.jpg": "dog", "drafts/c.jpg": "pig"}, nil, map[string]string{"images/drafts": "drafts"}, "orphan [drafts/c.jpg]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := listObjectsServer(tt.objects)
+			defer server.Close()
+
+			excludeGlobs = tt.exclude
+			defer func() { excludeGlobs = nil }()
+			if err := loadExcludePatterns(root); err != nil {
+				t.Fatal(err)
+			}
+			config := &PandoraConfig{ProjectRoot: root}
+			s3Config := testS3Config(server.URL)
+			client := &BucketClient{Client: newS3Client("s3", s3Config, true), Bucket: s3Config.Bucket, Prefixes: tt.prefixes}
+			discrepancy, err := CheckSync(client, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if discrepancy != nil {
+				got = strings.SplitN(discrepancy.String(), " from ", 2)[0]
+			}
+			if got != tt.want {
+				t.Errorf("The discrepancy is %q, want %q", got, tt.want)
+			}
+		})
+	}
+	excludePatterns = nil
+}
@@ -5,175 +5,514 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/spf13/cobra"
 	"go.yaml.in/yaml/v4"
 )
 
 func init() {
 	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configValidateCmd.Flags().BoolVar(&validateS3, "s3", false, "Test the connectivity of the S3 buckets by a HEAD bucket request")
 
-	rootCmd.Flags().StringVarP(&configPath, "config", "c", DefaultConfigRoot(), "The config file directory")
+	// The values which aren't given by the flags or the environment variables are prompted on the terminal.
+	configCmd.Flags().String("project-root", "", "The project root, or the PANDORA_PROJECT_ROOT env, it's the current directory by default")
+	configCmd.Flags().String("base-url", "", "The public base URL of the bucket, or the PANDORA_BASE_URL env, it's "+DefaultBaseURL+" by default")
+	configCmd.Flags().Int("quality", DefaultQuality, "The convert quality from 1 to 100, or the PANDORA_CONVERT_QUALITY env")
+	configCmd.Flags().String("format", JPG, "The convert format, or the PANDORA_CONVERT_FORMAT env")
+	configCmd.Flags().String("s3-region", "", "The s3 region, or the PANDORA_S3_REGION env, it's auto with the endpoint")
+	configCmd.Flags().String("s3-endpoint", "", "The s3 endpoint, or the PANDORA_S3_ENDPOINT env")
+	configCmd.Flags().String("s3-bucket", "", "The s3 bucket, or the PANDORA_S3_BUCKET env")
+	configCmd.Flags().String("s3-access-key", "", "The s3 access key, or the PANDORA_S3_ACCESS_KEY env, blank for the AWS credential chain")
+	configCmd.Flags().String("s3-access-secret-key", "", "The s3 access secret key, or the PANDORA_S3_ACCESS_SECRET_KEY env, required with the access key")
+	for _, option := range configOptions {
+		option.define(configCmd, fmt.Sprintf("The %s in config, or the %s env", option.path, option.env()))
+	}
+
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", DefaultConfigRoot(), "The config file directory, multiple directories separated by "+string(os.PathListSeparator)+" are merged with the later ones overriding")
 }
 
 const (
 	ConfigFileName = "gifts.yml"
+	DefaultBaseURL = "https://cdn.yufan.me"
+	DefaultQuality = 75
+	MinQuality     = 1
+	MaxQuality     = 100
+	// DefaultOGWidth and DefaultOGHeight are the open graph image size for the social previews.
+	DefaultOGWidth  = 1200
+	DefaultOGHeight = 630
+	// AutoProjectRoot detects the project root by the project markers, like the .git directory.
+	AutoProjectRoot = "auto"
 )
 
 var (
+	validateS3        bool
+	configValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration file and report all the problems",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadConfig()
+			if err != nil {
+				return &ExitError{Code: ExitConfig, Err: err}
+			}
+			errs := c.Validate()
+			if validateS3 && len(errs) == 0 {
+				errs = append(errs, checkBucket("s3", &c.S3))
+				if c.Sync.Mirror != nil {
+					errs = append(errs, checkBucket("sync.mirror", c.Sync.Mirror))
+				}
+				errs = slices.DeleteFunc(errs, func(err error) bool { return err == nil })
+			}
+			if err := reportConfigErrors(errs); err != nil {
+				return err
+			}
+			successf("Successfully validated the config file %s", configPath)
+			return nil
+		},
+	}
 	configCmd = &cobra.Command{
 		Use:   "config",
 		Short: "Generate a global configuration file for pandora tool",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(filepath.SplitList(configPath)) > 1 {
+				return exitErrorf(ExitConfig, "Only one config directory could be initialized, the merged directories are given: %s", configPath)
+			}
 			stat, err := os.Stat(configPath)
 			if errors.Is(err, os.ErrNotExist) {
 				err = os.MkdirAll(configPath, os.FileMode(0755))
 				if err != nil {
-					log.Fatalf("Failed to create the config path %s\nError: %v", configPath, err)
+					return exitErrorf(ExitFailure, "Failed to create the config path %s\nError: %v", configPath, err)
 				}
 			} else if err != nil || !stat.IsDir() {
-				log.Fatalf("Invalid config path %s.", configPath)
+				return exitErrorf(ExitConfig, "Invalid config path %s.", configPath)
 			}
 
-			configFile := filepath.Join(configPath, ConfigFileName)
-			file, err := os.OpenFile(configFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0644))
-			if err != nil {
-				log.Fatalf("Failed to create config file %s\nError: %v", configFile, err)
-			}
-			writer := bufio.NewWriter(file)
-
-			var (
-				projectRoot       string
-				convertQuality    int
-				convertFormat     string
-				s3Region          string
-				s3Endpoint        string
-				s3Bucket          string
-				s3AccessKey       string
-				s3AccessSecretKey string
-			)
-
+			// The values are resolved from the flags, the environment variables or the prompts in order.
 			executeRoot, _ := os.Getwd()
-			fmt.Printf("Please input the project root. Default [.]")
-			_, _ = fmt.Scanln(&projectRoot)
-			if projectRoot == "" {
-				projectRoot = executeRoot
-			}
+			projectRoot := configValue(cmd, "project-root", "PANDORA_PROJECT_ROOT", "Please input the project root. Default [.]", executeRoot)
+			baseURL := configValue(cmd, "base-url", "PANDORA_BASE_URL",
+				fmt.Sprintf("Please input the public base URL of the bucket. Default [%s]", DefaultBaseURL), DefaultBaseURL)
 
-			fmt.Println("Please input the convert quality. Default [75]")
-			_, _ = fmt.Scanf("%d", &convertQuality)
-			if convertQuality == 0 {
-				convertQuality = 75
+			qualityPrompt := fmt.Sprintf("Please input the convert quality, from 1 to 100. Default [%d]", DefaultQuality)
+			quality := configValue(cmd, "quality", "PANDORA_CONVERT_QUALITY", qualityPrompt, strconv.Itoa(DefaultQuality))
+			convertQuality, err := strconv.Atoi(quality)
+			for err != nil || !isValidQuality(convertQuality) {
+				if !canPrompt() {
+					return exitErrorf(ExitConfig, "Invalid convert quality %s, it should be between %d and %d", quality, MinQuality, MaxQuality)
+				}
+				fmt.Printf("Invalid convert quality %s, it should be between %d and %d\n", quality, MinQuality, MaxQuality)
+				quality = promptValue(qualityPrompt, strconv.Itoa(DefaultQuality))
+				convertQuality, err = strconv.Atoi(quality)
 			}
 
-			fmt.Println("Please input the convert format. Default [jpg]")
-			_, _ = fmt.Scanln(&convertFormat)
-			if convertFormat == "" {
-				convertFormat = JPG
-			} else {
-				if _, ok := supportExtensions[convertFormat]; !ok {
-					log.Fatalf("Unsupported convert format: %s", convertFormat)
-				}
+			convertFormat := configValue(cmd, "format", "PANDORA_CONVERT_FORMAT", "Please input the convert format. Default [jpg]", JPG)
+			if _, ok := supportExtensions[convertFormat]; !ok {
+				return exitErrorf(ExitConfig, "Unsupported convert format: %s", convertFormat)
 			}
 
+			s3Region := configValue(cmd, "s3-region", "PANDORA_S3_REGION", "Please input the s3 region (Optional)", "")
+			s3Endpoint := configValue(cmd, "s3-endpoint", "PANDORA_S3_ENDPOINT", "Please input the s3 endpoint (Optional)", "")
 			for s3Region == "" && s3Endpoint == "" {
-				fmt.Println("Please input the s3 region (Optional)")
-				_, _ = fmt.Scanln(&s3Region)
-
-				fmt.Println("Please input the s3 endpoint (Optional)")
-				_, _ = fmt.Scanln(&s3Endpoint)
+				if !canPrompt() {
+					return exitErrorf(ExitConfig, "The s3 region or endpoint is required, set it by --s3-region, --s3-endpoint or the PANDORA_S3_REGION, PANDORA_S3_ENDPOINT environment variables")
+				}
+				s3Region = promptValue("Please input the s3 region (Optional)", "")
+				s3Endpoint = promptValue("Please input the s3 endpoint (Optional)", "")
 			}
-
 			if s3Region == "" {
 				s3Region = "auto"
 			}
 
-			for s3Bucket == "" {
-				fmt.Println("Please input the s3 bucket")
-				_, _ = fmt.Scanln(&s3Bucket)
+			s3Bucket, err := requiredConfigValue(cmd, "s3-bucket", "PANDORA_S3_BUCKET", "Please input the s3 bucket")
+			if err != nil {
+				return err
 			}
-
-			for s3AccessKey == "" {
-				fmt.Println("Please input the s3 access key")
-				_, _ = fmt.Scanln(&s3AccessKey)
+			// The blank credentials are loaded from the AWS credential chain, the secret key is required with the access key.
+			s3AccessKey := configValue(cmd, "s3-access-key", "PANDORA_S3_ACCESS_KEY", "Please input the s3 access key (Optional)", "")
+			s3AccessSecretKey := ""
+			if s3AccessKey != "" {
+				if s3AccessSecretKey, err = requiredConfigValue(cmd, "s3-access-secret-key", "PANDORA_S3_ACCESS_SECRET_KEY", "Please input the s3 access secret key"); err != nil {
+					return err
+				}
+			} else if cmd.Flags().Changed("s3-access-secret-key") || os.Getenv("PANDORA_S3_ACCESS_SECRET_KEY") != "" {
+				return exitErrorf(ExitConfig, "The --s3-access-secret-key is given without the --s3-access-key, set both or neither for the AWS credential chain")
 			}
 
-			for s3AccessSecretKey == "" {
-				fmt.Println("Please input the s3 access secret key")
-				_, _ = fmt.Scanln(&s3AccessSecretKey)
+			var cs PandoraConfig
+			cs.ProjectRoot = projectRoot
+			cs.BaseURL = baseURL
+			cs.Convert.DefaultQuality = convertQuality
+			cs.Convert.DefaultFormat = convertFormat
+			cs.S3.Region = s3Region
+			cs.S3.Endpoint = s3Endpoint
+			cs.S3.Bucket = s3Bucket
+			cs.S3.AccessKey = s3AccessKey
+			cs.S3.AccessSecretKey = s3AccessSecretKey
+			if err := applyConfigOptions(cmd, &cs); err != nil {
+				return err
 			}
-
-			var cs = PandoraConfig{
-				ProjectRoot: projectRoot,
-				Convert: struct {
-					DefaultQuality int    `yaml:"defaultQuality"`
-					DefaultFormat  string `yaml:"defaultFormat"`
-				}{
-					DefaultQuality: convertQuality,
-					DefaultFormat:  convertFormat,
-				},
-				S3: struct {
-					Region          string `yaml:"region"`
-					Endpoint        string `yaml:"endpoint"`
-					Bucket          string `yaml:"bucket"`
-					AccessKey       string `yaml:"accessKey"`
-					AccessSecretKey string `yaml:"accessSecretKey"`
-				}{
-					Region:          s3Region,
-					Endpoint:        s3Endpoint,
-					Bucket:          s3Bucket,
-					AccessKey:       s3AccessKey,
-					AccessSecretKey: s3AccessSecretKey,
-				},
+			if err := reportConfigErrors(append(cs.validateSettings(), cs.S3.validate("s3")...)); err != nil {
+				return err
 			}
 
+			// The config file is only truncated after all the values are resolved and validated.
+			configFile := filepath.Join(configPath, ConfigFileName)
+			file, err := os.OpenFile(configFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0644))
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to create config file %s\nError: %v", configFile, err)
+			}
+			writer := bufio.NewWriter(file)
 			encoder := yaml.NewEncoder(writer)
 			encoder.SetIndent(2)
 			err = encoder.Encode(&cs)
 			if err != nil {
-				log.Fatalf("Failed to generate the configuration file: %v\nError: %v", configFile, err)
+				return exitErrorf(ExitFailure, "Failed to generate the configuration file: %v\nError: %v", configFile, err)
 			}
 
 			err = writer.Flush()
 			if err != nil {
-				log.Fatalf("Failed to write config file: %v", err)
+				return exitErrorf(ExitFailure, "Failed to write config file: %v", err)
 			}
+			successf("Successfully generate the config file %s", configFile)
+			return nil
 		},
 	}
 	configPath string
 )
 
 type PandoraConfig struct {
-	// The root file for storing the images
+	// The root file for storing the images, empty or auto for detecting it by the project markers.
 	ProjectRoot string `yaml:"projectRoot"`
-	Convert     struct {
+	// The extra file name which marks the project root, the .git and .pandora are always detected.
+	ProjectMarker string `yaml:"projectMarker,omitempty"`
+	// The public URL which maps to the bucket root
+	BaseURL string `yaml:"baseURL"`
+	// The directory for the intermediate files on the fast or large storage, empty for the os.TempDir().
+	TempDir string `yaml:"tempDir,omitempty"`
+	Convert struct {
 		DefaultQuality int    `yaml:"defaultQuality"`
 		DefaultFormat  string `yaml:"defaultFormat"`
+		// The regular expression for finding the date in the source file name by --time filename.
+		// The first capture group, or the whole match, is parsed in 20060102 format.
+		FilenameDatePattern string `yaml:"filenameDatePattern,omitempty"`
+		// The max size of the longest side for all the converted images, 0 for no limit.
+		MaxDimension int `yaml:"maxDimension,omitempty"`
+		// Name the converted images by the daily sequence like 20240101-001 instead of the timestamp.
+		SequenceNaming bool `yaml:"sequenceNaming,omitempty"`
+		// The social preview size generated by image --og, it's 1200x630 by default.
+		OG struct {
+			Width  int `yaml:"width,omitempty"`
+			Height int `yaml:"height,omitempty"`
+		} `yaml:"og,omitempty"`
 	} `yaml:"convert"`
-	S3 struct {
-		Region          string `yaml:"region"`
-		Endpoint        string `yaml:"endpoint"`
-		Bucket          string `yaml:"bucket"`
-		AccessKey       string `yaml:"accessKey"`
-		AccessSecretKey string `yaml:"accessSecretKey"`
-	} `yaml:"s3"`
+	S3   S3Config `yaml:"s3"`
+	Sync struct {
+		// The metadata shard scheme, one of none, year, directory. It's none by default.
+		MetadataShard string `yaml:"metadataShard,omitempty"`
+		// The change detection of the synced files, one of list, head. It's list by default.
+		// The head mode sends a HEAD request for every file instead of listing the directories.
+		CompareMode string `yaml:"compareMode,omitempty"`
+		// Record the SHA-256 digest of every image in the metadata, one of hex, base64. It's empty for not recording it.
+		SHA256 string `yaml:"sha256,omitempty"`
+		// The MIME categories (image, video, audio, document) mapped to the remote top-level directories,
+		// like video: videos. They take precedence over the prefix map.
+		RouteByType map[string]string `yaml:"routeByType,omitempty"`
+		// The local directories relative to the project root mapped to the remote key prefixes, like images: img.
+		PrefixMap map[string]string `yaml:"prefixMap,omitempty"`
+		// Convert the object keys into lowercase and URL safe slugs, the local files are untouched.
+		Slugify bool `yaml:"slugify,omitempty"`
+		// The _headers file uploaded into the bucket root for Cloudflare Pages or Netlify, empty for not generating it.
+		HeadersFile string `yaml:"headersFile,omitempty"`
+		// The header rules written into the headers file.
+		Headers []HeaderRule `yaml:"headers,omitempty"`
+		// The Cache-Control of the uploaded objects, the images are public, max-age=31536000, immutable if it's empty.
+		// The image metadata is always no-cache.
+		CacheControl string `yaml:"cacheControl,omitempty"`
+		// Upload an index object listing the immediate children into every directory, one of json, html.
+		// It's empty for not generating the index.
+		DirectoryIndex string `yaml:"directoryIndex,omitempty"`
+		// The backup bucket which mirrors every uploaded object, the upload failures of it are only warned.
+		Mirror *S3Config `yaml:"mirror,omitempty"`
+	} `yaml:"sync,omitempty"`
+	Vips struct {
+		// The number of libvips worker threads for a single image, 0 for the bimg default (1).
+		Concurrency int `yaml:"concurrency,omitempty"`
+		// The max number of operations in the libvips cache, 0 for the bimg default (500).
+		CacheMax int `yaml:"cacheMax,omitempty"`
+		// The max memory in bytes for the libvips cache, 0 for the bimg default (100MB).
+		CacheMaxMem int `yaml:"cacheMaxMem,omitempty"`
+	} `yaml:"vips,omitempty"`
+	Log struct {
+		// The file for appending the log output, empty for logging to stderr only.
+		File string `yaml:"file,omitempty"`
+		// The log file is rotated once it exceeds the max size in bytes, 0 for always appending.
+		MaxSize int64 `yaml:"maxSize,omitempty"`
+	} `yaml:"log,omitempty"`
+	Metadata struct {
+		// The placeholder generated in the image metadata, it's webp by default.
+		BlurFormat string `yaml:"blurFormat,omitempty"`
+	} `yaml:"metadata,omitempty"`
+	// The named conversion presets selected by image --preset.
+	Presets map[string]ImagePreset `yaml:"presets,omitempty"`
+}
+
+// S3Config is the S3 compatible storage for syncing the files.
+type S3Config struct {
+	Region          string `yaml:"region"`
+	Endpoint        string `yaml:"endpoint"`
+	Bucket          string `yaml:"bucket"`
+	AccessKey       string `yaml:"accessKey"`
+	AccessSecretKey string `yaml:"accessSecretKey"`
+	// The shared AWS profile for the credentials when the accessKey and accessSecretKey are empty,
+	// empty for the AWS_PROFILE environment variable or the default profile.
+	Profile string `yaml:"profile,omitempty"`
+	// The object size in bytes from which the multipart upload is used, 0 for the default 100MB.
+	MultipartThreshold int64 `yaml:"multipartThreshold,omitempty"`
+	// The part size in bytes for multipart uploads, 0 for the SDK default (5MB).
+	MultipartPartSize int64 `yaml:"multipartPartSize,omitempty"`
+	// The HTTP proxy URL for the S3 calls, empty for the HTTPS_PROXY environment variable.
+	Proxy string `yaml:"proxy,omitempty"`
+	// Use the path-style requests for the custom endpoint, it's detected by a HEAD bucket request if it's unset.
+	UsePathStyle *bool `yaml:"usePathStyle,omitempty"`
+	// The error codes retried for the true value or failed immediately for the false value, other codes
+	// are left to the standard retryable set, like the nonstandard rate limiting codes of the providers.
+	RetryableErrors map[string]bool `yaml:"retryableErrors,omitempty"`
+}
+
+// ImagePreset is a group of the image command options, the zero values are left to the flags.
+type ImagePreset struct {
+	Width        int    `yaml:"width,omitempty"`
+	Height       int    `yaml:"height,omitempty"`
+	Format       string `yaml:"format,omitempty"`
+	Quality      int    `yaml:"quality,omitempty"`
+	Aspect       string `yaml:"aspect,omitempty"`
+	Gravity      string `yaml:"gravity,omitempty"`
+	Normalize    bool   `yaml:"normalize,omitempty"`
+	MaxBytes     int    `yaml:"maxBytes,omitempty"`
+	MaxDimension int    `yaml:"maxDimension,omitempty"`
+}
+
+func (c *PandoraConfig) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return c.S3.Retrieve(ctx)
 }
 
-func (c *PandoraConfig) Retrieve(context.Context) (aws.Credentials, error) {
-	if c.S3.AccessKey == "" || c.S3.AccessSecretKey == "" {
-		return aws.Credentials{}, fmt.Errorf("no accessKey or AccessSecretKey is provided")
+// Retrieve returns the inline keys in config file, or the credentials from the standard AWS credential chain
+// if both of them are empty.
+func (c *S3Config) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if c.AccessKey == "" && c.AccessSecretKey == "" {
+		provider, err := credentialChain(ctx, c.Profile)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		return provider.Retrieve(ctx)
+	}
+	if c.AccessKey == "" || c.AccessSecretKey == "" {
+		return aws.Credentials{}, fmt.Errorf("both the accessKey and accessSecretKey are required")
 	}
 
 	return aws.Credentials{
-		AccessKeyID:     c.S3.AccessKey,
-		SecretAccessKey: c.S3.AccessSecretKey,
+		AccessKeyID:     c.AccessKey,
+		SecretAccessKey: c.AccessSecretKey,
 	}, nil
 }
 
+var (
+	credentialChains     = map[string]aws.CredentialsProvider{}
+	credentialChainsLock sync.Mutex
+)
+
+// credentialChain loads the standard AWS credential chain of the profile once, the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables come first, then the shared ~/.aws/credentials and ~/.aws/config.
+// The returned provider caches the credentials until they're expired.
+func credentialChain(ctx context.Context, profile string) (aws.CredentialsProvider, error) {
+	credentialChainsLock.Lock()
+	defer credentialChainsLock.Unlock()
+	if provider, ok := credentialChains[profile]; ok {
+		return provider, nil
+	}
+
+	var options []func(*awsconfig.LoadOptions) error
+	if profile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(profile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the AWS credentials of the profile %q: %v", profile, err)
+	}
+	credentialChains[profile] = cfg.Credentials
+	return cfg.Credentials, nil
+}
+
+// configValue resolves the value of the config command from the flag, the environment variable, or the prompt
+// on the terminal. The fallback is used if it's still empty, the prompt never blocks the scripts without stdin.
+func configValue(cmd *cobra.Command, flag, env, prompt, fallback string) string {
+	if cmd.Flags().Changed(flag) {
+		return cmd.Flags().Lookup(flag).Value.String()
+	}
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	if !canPrompt() {
+		return fallback
+	}
+	return promptValue(prompt, fallback)
+}
+
+// requiredConfigValue is the configValue without the fallback, it fails on the missing value without a terminal.
+func requiredConfigValue(cmd *cobra.Command, flag, env, prompt string) (string, error) {
+	value := configValue(cmd, flag, env, prompt, "")
+	for value == "" {
+		if !canPrompt() {
+			return "", exitErrorf(ExitConfig, "The --%s is required, set it by the flag or the %s environment variable", flag, env)
+		}
+		value = promptValue(prompt, "")
+	}
+	return value, nil
+}
+
+// configOption binds an optional config field to the flag and the PANDORA_ environment variable named after the flag.
+// The options are never prompted, the unset fields are omitted from the config file. The maps and lists like the
+// sync.prefixMap, sync.headers, s3.retryableErrors, presets and the sync.mirror bucket have no flag, they are edited
+// in the config file.
+type configOption struct {
+	flag string
+	// The field path in the config file.
+	path   string
+	define func(cmd *cobra.Command, usage string)
+	apply  func(c *PandoraConfig, value string) error
+}
+
+func (option configOption) env() string {
+	return "PANDORA_" + strings.ToUpper(strings.ReplaceAll(option.flag, "-", "_"))
+}
+
+func stringOption(flag, path string, set func(c *PandoraConfig, value string)) configOption {
+	return configOption{
+		flag:   flag,
+		path:   path,
+		define: func(cmd *cobra.Command, usage string) { cmd.Flags().String(flag, "", usage) },
+		apply: func(c *PandoraConfig, value string) error {
+			set(c, value)
+			return nil
+		},
+	}
+}
+
+func intOption(flag, path string, set func(c *PandoraConfig, value int64)) configOption {
+	return configOption{
+		flag:   flag,
+		path:   path,
+		define: func(cmd *cobra.Command, usage string) { cmd.Flags().Int64(flag, 0, usage) },
+		apply: func(c *PandoraConfig, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("the %s should be an integer", path)
+			}
+			set(c, n)
+			return nil
+		},
+	}
+}
+
+func boolOption(flag, path string, set func(c *PandoraConfig, value bool)) configOption {
+	return configOption{
+		flag:   flag,
+		path:   path,
+		define: func(cmd *cobra.Command, usage string) { cmd.Flags().Bool(flag, false, usage) },
+		apply: func(c *PandoraConfig, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the %s should be true or false", path)
+			}
+			set(c, b)
+			return nil
+		},
+	}
+}
+
+var configOptions = []configOption{
+	stringOption("project-marker", "projectMarker", func(c *PandoraConfig, v string) { c.ProjectMarker = v }),
+	stringOption("temp-dir", "tempDir", func(c *PandoraConfig, v string) { c.TempDir = v }),
+	stringOption("convert-filename-date-pattern", "convert.filenameDatePattern", func(c *PandoraConfig, v string) { c.Convert.FilenameDatePattern = v }),
+	intOption("convert-max-dimension", "convert.maxDimension", func(c *PandoraConfig, v int64) { c.Convert.MaxDimension = int(v) }),
+	boolOption("convert-sequence-naming", "convert.sequenceNaming", func(c *PandoraConfig, v bool) { c.Convert.SequenceNaming = v }),
+	intOption("convert-og-width", "convert.og.width", func(c *PandoraConfig, v int64) { c.Convert.OG.Width = int(v) }),
+	intOption("convert-og-height", "convert.og.height", func(c *PandoraConfig, v int64) { c.Convert.OG.Height = int(v) }),
+	stringOption("s3-profile", "s3.profile", func(c *PandoraConfig, v string) { c.S3.Profile = v }),
+	intOption("s3-multipart-threshold", "s3.multipartThreshold", func(c *PandoraConfig, v int64) { c.S3.MultipartThreshold = v }),
+	intOption("s3-multipart-part-size", "s3.multipartPartSize", func(c *PandoraConfig, v int64) { c.S3.MultipartPartSize = v }),
+	stringOption("s3-proxy", "s3.proxy", func(c *PandoraConfig, v string) { c.S3.Proxy = v }),
+	boolOption("s3-use-path-style", "s3.usePathStyle", func(c *PandoraConfig, v bool) { c.S3.UsePathStyle = aws.Bool(v) }),
+	stringOption("sync-metadata-shard", "sync.metadataShard", func(c *PandoraConfig, v string) { c.Sync.MetadataShard = v }),
+	stringOption("sync-compare-mode", "sync.compareMode", func(c *PandoraConfig, v string) { c.Sync.CompareMode = v }),
+	stringOption("sync-sha256", "sync.sha256", func(c *PandoraConfig, v string) { c.Sync.SHA256 = v }),
+	boolOption("sync-slugify", "sync.slugify", func(c *PandoraConfig, v bool) { c.Sync.Slugify = v }),
+	stringOption("sync-headers-file", "sync.headersFile", func(c *PandoraConfig, v string) { c.Sync.HeadersFile = v }),
+	stringOption("sync-cache-control", "sync.cacheControl", func(c *PandoraConfig, v string) { c.Sync.CacheControl = v }),
+	stringOption("sync-directory-index", "sync.directoryIndex", func(c *PandoraConfig, v string) { c.Sync.DirectoryIndex = v }),
+	intOption("vips-concurrency", "vips.concurrency", func(c *PandoraConfig, v int64) { c.Vips.Concurrency = int(v) }),
+	intOption("vips-cache-max", "vips.cacheMax", func(c *PandoraConfig, v int64) { c.Vips.CacheMax = int(v) }),
+	intOption("vips-cache-max-mem", "vips.cacheMaxMem", func(c *PandoraConfig, v int64) { c.Vips.CacheMaxMem = int(v) }),
+	// The --log-file is the global flag of the current run.
+	stringOption("log-path", "log.file", func(c *PandoraConfig, v string) { c.Log.File = v }),
+	intOption("log-max-size", "log.maxSize", func(c *PandoraConfig, v int64) { c.Log.MaxSize = v }),
+	stringOption("metadata-blur-format", "metadata.blurFormat", func(c *PandoraConfig, v string) { c.Metadata.BlurFormat = v }),
+}
+
+// applyConfigOptions fills the optional fields given by the flags or the environment variables.
+func applyConfigOptions(cmd *cobra.Command, c *PandoraConfig) error {
+	for _, option := range configOptions {
+		value := os.Getenv(option.env())
+		if cmd.Flags().Changed(option.flag) {
+			value = cmd.Flags().Lookup(option.flag).Value.String()
+		} else if value == "" {
+			continue
+		}
+		if err := option.apply(c, value); err != nil {
+			return exitErrorf(ExitConfig, "Invalid value %s of the --%s or the %s env\nError: %v", value, option.flag, option.env(), err)
+		}
+	}
+	return nil
+}
+
+// stdinClosed is set once the prompt reads the end of stdin, like the /dev/null which looks like a terminal.
+var stdinClosed bool
+
+func canPrompt() bool {
+	return !stdinClosed && isTerminal(os.Stdin)
+}
+
+func promptValue(prompt, fallback string) string {
+	var value string
+	fmt.Println(prompt)
+	if _, err := fmt.Scanln(&value); errors.Is(err, io.EOF) {
+		stdinClosed = true
+	}
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// isValidQuality checks the quality is in the 1 - 100 scale used by libvips.
+func isValidQuality(quality int) bool {
+	return quality >= MinQuality && quality <= MaxQuality
+}
+
 func DefaultConfigRoot() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -182,26 +521,286 @@ func DefaultConfigRoot() string {
 	return filepath.Join(home, ".config", "pandora")
 }
 
+// mergeConfigValues deep merges the override into the base config values. The maps are merged key by key,
+// the scalars and the lists in the override replace the base ones.
+func mergeConfigValues(base, override map[string]any) map[string]any {
+	if base == nil {
+		base = map[string]any{}
+	}
+	for key, value := range override {
+		baseMap, ok1 := base[key].(map[string]any)
+		overrideMap, ok2 := value.(map[string]any)
+		if ok1 && ok2 {
+			base[key] = mergeConfigValues(baseMap, overrideMap)
+		} else {
+			base[key] = value
+		}
+	}
+	return base
+}
+
+// resolveConfigPath prefers the project-local config in the current directory
+// unless the config directory is given explicitly.
+func resolveConfigPath() string {
+	if rootCmd.PersistentFlags().Changed("config") {
+		return configPath
+	}
+	local := filepath.Join(".", ProjectConfigDir)
+	if _, err := os.Stat(filepath.Join(local, ConfigFileName)); err == nil {
+		return local
+	}
+	return configPath
+}
+
+// detectProjectRoot walks up from the current directory until a directory contains a project marker.
+func detectProjectRoot(marker string) (string, bool) {
+	markers := []string{".git", ProjectConfigDir}
+	if marker != "" {
+		markers = append(markers, marker)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	for {
+		for _, m := range markers {
+			if _, err := os.Stat(filepath.Join(dir, m)); err == nil {
+				return dir, true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
 // ReadConfig will load the yaml based configuration file and deserialize it into the target path.
-func ReadConfig() *PandoraConfig {
-	// Initialize pandora config
-	stat, err := os.Stat(configPath)
-	if err != nil || !stat.IsDir() {
-		log.Fatalf(`It sees like you haven't config the tool.\nExecute the command "pandora config" for initializing.`)
+func ReadConfig() (*PandoraConfig, error) {
+	c, err := loadConfig()
+	if err != nil {
+		return nil, &ExitError{Code: ExitConfig, Err: err}
+	}
+	if errs := c.validateSettings(); len(errs) > 0 {
+		return nil, &ExitError{Code: ExitConfig, Err: errs[0]}
 	}
+	return c, nil
+}
 
-	file, err := os.Open(filepath.Join(configPath, ConfigFileName))
+// ReadValidConfig reads the config file and reports all the problems at once before running the command,
+// the S3 settings are only validated for the commands which upload the files.
+func ReadValidConfig(s3 bool) (*PandoraConfig, error) {
+	c, err := loadConfig()
 	if err != nil {
-		log.Fatalf("Failed to load the config file from: %s.\nError: %v", configPath, err)
+		return nil, &ExitError{Code: ExitConfig, Err: err}
+	}
+	errs := c.validateLocal()
+	if s3 {
+		errs = append(errs, c.validateS3()...)
 	}
+	if err := reportConfigErrors(errs); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reportConfigErrors logs every problem of the config file and returns the ExitConfig error if there is any.
+func reportConfigErrors(errs []error) error {
+	for _, err := range errs {
+		errorf("Error: %v", err)
+	}
+	if len(errs) > 0 {
+		return exitErrorf(ExitConfig, "Found %d problems in the config file %s", len(errs), configPath)
+	}
+	return nil
+}
 
-	reader := bufio.NewReader(file)
-	decoder := yaml.NewDecoder(reader)
+// loadConfig reads and merges the config files, the defaults and the environment variables are applied.
+func loadConfig() (*PandoraConfig, error) {
+	// The later config directories override the earlier ones, the last one holds the state files.
+	dirs := filepath.SplitList(resolveConfigPath())
+	var merged map[string]any
+	// rootDir is the config directory which sets the projectRoot, the later one wins like the other values.
+	rootDir := ""
+	for _, dir := range dirs {
+		stat, err := os.Stat(dir)
+		if err != nil || !stat.IsDir() {
+			return nil, errors.New(`It sees like you haven't config the tool.\nExecute the command "pandora config" for initializing.`)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, ConfigFileName))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load the config file from: %s.\nError: %v", dir, err)
+		}
+		var values map[string]any
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("Invalid config file format or location %s.\nError: %v", dir, err)
+		}
+		if _, ok := values["projectRoot"]; ok {
+			rootDir = dir
+		}
+		merged = mergeConfigValues(merged, values)
+	}
+	configPath = dirs[len(dirs)-1]
 
-	var c PandoraConfig
-	err = decoder.Decode(&c)
+	content, err := yaml.Marshal(merged)
 	if err != nil {
-		log.Fatalf("Invalid config file format or location %s.\nError: %v", configPath, err)
+		return nil, fmt.Errorf("Failed to merge the config files from: %s.\nError: %v", strings.Join(dirs, ", "), err)
+	}
+	var c PandoraConfig
+	if err := yaml.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("Invalid config file format or location %s.\nError: %v", strings.Join(dirs, ", "), err)
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = DefaultBaseURL
+	}
+	if c.Convert.DefaultQuality == 0 {
+		c.Convert.DefaultQuality = DefaultQuality
+	}
+	// The project root is detected from the current directory by the project markers, it's left empty
+	// if no marker is found, and the commands which require it fail on the validation.
+	if c.ProjectRoot == "" || c.ProjectRoot == AutoProjectRoot {
+		c.ProjectRoot = ""
+		if root, ok := detectProjectRoot(c.ProjectMarker); ok {
+			debugf("Detect the project root [%v]", root)
+			c.ProjectRoot = root
+		}
+	} else if !filepath.IsAbs(c.ProjectRoot) {
+		// The relative project root in the project-local .pandora config is resolved against the project
+		// which holds the config directory, the one in the global config is relative to the current directory.
+		base := "."
+		if filepath.Base(filepath.Clean(rootDir)) == ProjectConfigDir {
+			base = filepath.Dir(filepath.Clean(rootDir))
+		}
+		root, e := filepath.Abs(filepath.Join(base, c.ProjectRoot))
+		if e != nil {
+			return nil, fmt.Errorf("Invalid project root %s.\nError: %v", c.ProjectRoot, e)
+		}
+		c.ProjectRoot = root
+	}
+	// The secrets could be provided by the environment variables.
+	if key := os.Getenv("PANDORA_S3_ACCESS_KEY"); key != "" {
+		c.S3.AccessKey = key
+	}
+	if secret := os.Getenv("PANDORA_S3_ACCESS_SECRET_KEY"); secret != "" {
+		c.S3.AccessSecretKey = secret
+	}
+	return &c, nil
+}
+
+// Validate checks the whole config and returns all the problems. The commands only require
+// the valid settings, the S3 fields and the project root are checked when they are used.
+func (c *PandoraConfig) Validate() []error {
+	return append(c.validateLocal(), c.validateS3()...)
+}
+
+// validateLocal checks the settings besides the S3 buckets.
+func (c *PandoraConfig) validateLocal() []error {
+	errs := c.validateSettings()
+	if c.ProjectRoot == "" {
+		errs = append(errs, fmt.Errorf("The projectRoot couldn't be detected from the current directory without the %s or .git, "+
+			"set projectRoot in config file or run the command in the project", ProjectConfigDir))
+	} else if stat, err := os.Stat(c.ProjectRoot); err != nil || !stat.IsDir() {
+		errs = append(errs, fmt.Errorf("The projectRoot %s doesn't exist or isn't a directory", c.ProjectRoot))
+	}
+	if _, ok := supportExtensions[c.Convert.DefaultFormat]; c.Convert.DefaultFormat != "" && !ok {
+		errs = append(errs, fmt.Errorf("Invalid convert.defaultFormat %s in config file, it should be one of %s", c.Convert.DefaultFormat, supportedFormats()))
+	}
+	for name, preset := range c.Presets {
+		if _, ok := supportExtensions[preset.Format]; preset.Format != "" && !ok {
+			errs = append(errs, fmt.Errorf("Invalid presets.%s.format %s in config file, it should be one of %s", name, preset.Format, supportedFormats()))
+		}
+		if preset.Quality != 0 && !isValidQuality(preset.Quality) {
+			errs = append(errs, fmt.Errorf("Invalid presets.%s.quality %d in config file, it should be between %d and %d", name, preset.Quality, MinQuality, MaxQuality))
+		}
+		if _, ok := gravities[preset.Gravity]; preset.Gravity != "" && !ok {
+			errs = append(errs, fmt.Errorf("Invalid presets.%s.gravity %s in config file, it should be one of %s", name, preset.Gravity, supportedGravities()))
+		}
+	}
+	return errs
+}
+
+// validateS3 checks the S3 bucket and the mirror bucket.
+func (c *PandoraConfig) validateS3() []error {
+	errs := c.S3.validate("s3")
+	if c.Sync.Mirror != nil {
+		errs = append(errs, c.Sync.Mirror.validate("sync.mirror")...)
+	}
+	return errs
+}
+
+// checkBucket tests the bucket is reachable with the configured credentials.
+func checkBucket(name string, config *S3Config) error {
+	client := newS3Client(name, config, config.Endpoint != "" && detectPathStyle(name, config))
+	if _, err := client.HeadBucket(operationContext, &s3.HeadBucketInput{Bucket: aws.String(config.Bucket)}); err != nil {
+		return fmt.Errorf("The %s.bucket %s is unreachable.\nError: %v", name, config.Bucket, err)
+	}
+	return nil
+}
+
+// validateSettings checks the optional settings, which are required to be valid by all the commands.
+func (c *PandoraConfig) validateSettings() []error {
+	var errs []error
+	if !isValidQuality(c.Convert.DefaultQuality) {
+		errs = append(errs, fmt.Errorf("Invalid convert.defaultQuality %d in config file, it should be between %d and %d", c.Convert.DefaultQuality, MinQuality, MaxQuality))
+	}
+	if !isValidShard(c.Sync.MetadataShard) {
+		errs = append(errs, fmt.Errorf("Invalid sync.metadataShard %s in config file, it should be one of %s, %s, %s", c.Sync.MetadataShard, ShardNone, ShardYear, ShardDirectory))
+	}
+	if c.Convert.OG.Width < 0 || c.Convert.OG.Height < 0 {
+		errs = append(errs, fmt.Errorf("Invalid convert.og size %dx%d in config file, it should be positive", c.Convert.OG.Width, c.Convert.OG.Height))
+	}
+	if c.Sync.SHA256 != "" && c.Sync.SHA256 != DigestHex && c.Sync.SHA256 != DigestBase64 {
+		errs = append(errs, fmt.Errorf("Invalid sync.sha256 %s in config file, it should be one of %s, %s", c.Sync.SHA256, DigestHex, DigestBase64))
+	}
+	for _, category := range sortedKeys(c.Sync.RouteByType) {
+		if !isValidRoute(category) {
+			errs = append(errs, fmt.Errorf("Invalid sync.routeByType category %s in config file, it should be one of %s, %s, %s, %s", category, RouteImage, RouteVideo, RouteAudio, RouteDocument))
+		}
+	}
+	if !isValidBlurFormat(c.Metadata.BlurFormat) {
+		errs = append(errs, fmt.Errorf("Invalid metadata.blurFormat %s in config file, it should be one of %s", c.Metadata.BlurFormat, supportedBlurFormats()))
+	}
+	if !isValidIndexFormat(c.Sync.DirectoryIndex) {
+		errs = append(errs, fmt.Errorf("Invalid sync.directoryIndex %s in config file, it should be one of %s, %s", c.Sync.DirectoryIndex, IndexJSON, IndexHTML))
+	}
+	if c.Sync.CompareMode != "" && c.Sync.CompareMode != CompareList && c.Sync.CompareMode != CompareHead {
+		errs = append(errs, fmt.Errorf("Invalid sync.compareMode %s in config file, it should be one of %s, %s", c.Sync.CompareMode, CompareList, CompareHead))
+	}
+	if _, err := regexp.Compile(c.Convert.FilenameDatePattern); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid convert.filenameDatePattern %s in config file.\nError: %v", c.Convert.FilenameDatePattern, err))
+	}
+	if _, err := renderHeadersFile(c.Sync.Headers); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid sync.headers in config file.\nError: %v", err))
+	}
+	return errs
+}
+
+// validate checks the required fields of the bucket and the consistency of the endpoint and region.
+func (c *S3Config) validate(name string) []error {
+	var errs []error
+	if c.Bucket == "" {
+		errs = append(errs, fmt.Errorf("The %s.bucket is required in config file", name))
+	}
+	if (c.AccessKey == "") != (c.AccessSecretKey == "") {
+		errs = append(errs, fmt.Errorf("The %s.accessKey and %s.accessSecretKey should be both set, or both empty for the AWS credential chain", name, name))
+	}
+	if c.Endpoint == "" && (c.Region == "" || c.Region == "auto") {
+		errs = append(errs, fmt.Errorf("The %s.region should be an AWS region like us-east-1 without the %s.endpoint", name, name))
+	}
+	if c.Endpoint != "" {
+		if endpoint, err := url.Parse(c.Endpoint); err != nil || endpoint.Host == "" || endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("Invalid %s.endpoint %s in config file, it should be a URL like https://s3.example.com", name, c.Endpoint))
+		}
+	}
+	if c.MultipartPartSize != 0 && c.MultipartPartSize < manager.MinUploadPartSize {
+		errs = append(errs, fmt.Errorf("Invalid %s.multipartPartSize %d, it should be at least %d bytes (5MB)", name, c.MultipartPartSize, manager.MinUploadPartSize))
+	}
+	if c.MultipartThreshold < 0 || c.MultipartThreshold > MaxPutObjectSize {
+		errs = append(errs, fmt.Errorf("Invalid %s.multipartThreshold %d, it should be at most %d bytes (5GB)", name, c.MultipartThreshold, MaxPutObjectSize))
+	}
+	if proxy, err := url.Parse(c.Proxy); c.Proxy != "" && (err != nil || proxy.Host == "") {
+		errs = append(errs, fmt.Errorf("Invalid %s.proxy %s, it should be a URL like http://127.0.0.1:7890", name, c.Proxy))
 	}
-	return &c
+	return errs
 }
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// writeTestConfig writes the config file into the directory.
+func writeTestConfig(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ConfigFileName), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectRoot(t *testing.T) {
+	workspace, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(workspace, "blog")
+	global := filepath.Join(workspace, "home", ".config", "pandora")
+	cwd := filepath.Join(workspace, "cwd")
+	if err := os.MkdirAll(cwd, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestConfig(t, filepath.Join(project, ProjectConfigDir), "projectRoot: site\n")
+	writeTestConfig(t, global, "projectRoot: site\n")
+	writeTestConfig(t, filepath.Join(workspace, "auto"), "projectRoot: auto\n")
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"project-local config", filepath.Join(project, ProjectConfigDir), filepath.Join(project, "site")},
+		{"global config", global, filepath.Join(cwd, "site")},
+		{"merged into the global config", filepath.Join(project, ProjectConfigDir) + string(os.PathListSeparator) + global, filepath.Join(cwd, "site")},
+		{"undetected", filepath.Join(workspace, "auto"), ""},
+	}
+	original := configPath
+	defer func() { configPath = original }()
+	t.Chdir(cwd)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := rootCmd.PersistentFlags().Set("config", tt.config); err != nil {
+				t.Fatal(err)
+			}
+			c, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.ProjectRoot != tt.want {
+				t.Errorf("The project root is %q, want %q", c.ProjectRoot, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigOptions(t *testing.T) {
+	newCommand := func(args ...string) *cobra.Command {
+		cmd := &cobra.Command{}
+		for _, option := range configOptions {
+			option.define(cmd, option.path)
+		}
+		if err := cmd.Flags().Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+
+	t.Setenv("PANDORA_SYNC_COMPARE_MODE", CompareHead)
+	t.Setenv("PANDORA_SYNC_SLUGIFY", "true")
+	t.Setenv("PANDORA_VIPS_CONCURRENCY", "4")
+	var c PandoraConfig
+	cmd := newCommand("--vips-concurrency", "2", "--s3-use-path-style=false", "--log-path", "pandora.log")
+	if err := applyConfigOptions(cmd, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Sync.CompareMode != CompareHead || !c.Sync.Slugify || c.Log.File != "pandora.log" {
+		t.Errorf("The options should be read from the flags and the env, got %+v", c)
+	}
+	if c.Vips.Concurrency != 2 {
+		t.Errorf("The flag should override the env, got the vips.concurrency %d", c.Vips.Concurrency)
+	}
+	if c.S3.UsePathStyle == nil || *c.S3.UsePathStyle {
+		t.Errorf("The s3.usePathStyle should be set to false, got %v", c.S3.UsePathStyle)
+	}
+	if c.Sync.MetadataShard != "" || c.Sync.CacheControl != "" {
+		t.Errorf("The unset options should be left empty, got %+v", c.Sync)
+	}
+
+	t.Setenv("PANDORA_LOG_MAX_SIZE", "1MB")
+	if err := applyConfigOptions(newCommand(), &PandoraConfig{}); err == nil || !strings.Contains(err.Error(), "PANDORA_LOG_MAX_SIZE") {
+		t.Errorf("The invalid integer should fail with the env name, got %v", err)
+	}
+}
+
+// TestConfigOptionsCoverage checks every scalar field of the config file could be given to the config command.
+func TestConfigOptionsCoverage(t *testing.T) {
+	bound := map[string]bool{
+		// The values prompted by the config command.
+		"projectRoot": true, "baseURL": true, "convert.defaultQuality": true, "convert.defaultFormat": true,
+		"s3.region": true, "s3.endpoint": true, "s3.bucket": true, "s3.accessKey": true, "s3.accessSecretKey": true,
+	}
+	for _, option := range configOptions {
+		if bound[option.path] {
+			t.Errorf("The %s is bound twice", option.path)
+		}
+		bound[option.path] = true
+	}
+
+	var walk func(typ reflect.Type, prefix string)
+	walk = func(typ reflect.Type, prefix string) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			path := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+			switch {
+			case field.Type.Kind() == reflect.Struct:
+				walk(field.Type, path+".")
+			case field.Type.Kind() == reflect.Map, field.Type.Kind() == reflect.Slice, field.Type == reflect.TypeOf(&S3Config{}):
+				// The maps, lists and the mirror bucket are edited in the config file.
+			case !bound[path]:
+				t.Errorf("The config field %s has no flag of the config command", path)
+			}
+		}
+	}
+	walk(reflect.TypeOf(PandoraConfig{}), "")
+}
+
+func TestConfigCredentials(t *testing.T) {
+	t.Setenv("PANDORA_S3_ACCESS_KEY", "")
+	t.Setenv("PANDORA_S3_ACCESS_SECRET_KEY", "")
+	original := configPath
+	defer func() {
+		configPath = original
+		rootCmd.SetArgs(nil)
+	}()
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantKey string
+		wantErr string
+	}{
+		{"credential chain", nil, "", ""},
+		{"inline keys", []string{"--s3-access-key", "access", "--s3-access-secret-key", "secret"}, "access", ""},
+		{"missing secret", []string{"--s3-access-key", "access"}, "", "--s3-access-secret-key is required"},
+		{"missing key", []string{"--s3-access-secret-key", "secret"}, "", "without the --s3-access-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"s3-access-key", "s3-access-secret-key"} {
+				configCmd.Flags().Lookup(name).Changed = false
+			}
+			dir := t.TempDir()
+			rootCmd.SetArgs(append([]string{"config", "--config", dir, "--s3-region", "us-east-1", "--s3-bucket", "pandora"}, tt.args...))
+			err := rootCmd.Execute()
+			if tt.wantErr != "" {
+				if err == nil || exitCode(err) != ExitConfig || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("The config command fails with %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := ReadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.S3.AccessKey != tt.wantKey {
+				t.Errorf("The s3.accessKey is %q, want %q", c.S3.AccessKey, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// TimeNow uses the current date.
+	TimeNow = "now"
+	// TimeExif reads the date from the EXIF DateTimeOriginal tag of the source image.
+	TimeExif = "exif"
+	// TimeFilename parses the date from the source file name.
+	TimeFilename = "filename"
+
+	DefaultFilenameDatePattern = `(\d{8})`
+	exifDateLayout             = "2006:01:02 15:04:05"
+)
+
+var timeModes = []string{TimeNow, TimeExif, TimeFilename}
+
+func isValidTimeMode(mode string) bool {
+	return slices.Contains(timeModes, mode)
+}
+
+// resolveImageDate resolves the date used for naming and foldering the image from the --time flag.
+// It falls back to the current date if the EXIF tag or the file name doesn't contain a valid date.
+func resolveImageDate(mode, name string, content []byte, config *PandoraConfig) time.Time {
+	switch mode {
+	case TimeNow:
+		return time.Now()
+	case TimeExif:
+		t, err := exifDate(content)
+		if err != nil {
+			warnf("Failed to read the EXIF of the image, use the current date instead.\nError: %v", err)
+			return time.Now()
+		}
+		if t.IsZero() {
+			infof("No date is found in the EXIF of the image, use the current date instead.")
+			return time.Now()
+		}
+		return t
+	case TimeFilename:
+		pattern := config.Convert.FilenameDatePattern
+		if pattern == "" {
+			pattern = DefaultFilenameDatePattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			warnf("Invalid convert.filenameDatePattern %s, use the current date instead.\nError: %v", pattern, err)
+			return time.Now()
+		}
+		if matches := re.FindStringSubmatch(filepath.Base(name)); matches != nil {
+			value := matches[0]
+			if len(matches) > 1 {
+				value = matches[1]
+			}
+			if t, err := time.ParseInLocation("20060102", value, time.Local); err == nil {
+				return t
+			}
+		}
+		infof("No date is found in the file name %s, use the current date instead.", filepath.Base(name))
+		return time.Now()
+	default:
+		t, _ := time.ParseInLocation("20060102", mode, time.Local)
+		return t
+	}
+}
+
+// exifDate reads the DateTimeOriginal or DateTime tag of the image, it's zero if there is no date in the EXIF.
+func exifDate(content []byte) (time.Time, error) {
+	metadata, err := bimg.Metadata(content)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, value := range []string{metadata.EXIF.DateTimeOriginal, metadata.EXIF.Datetime} {
+		if t, err := time.ParseInLocation(exifDateLayout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// datePrefixKey nests the image key under images/<year>/<month>/ like the image command saves it,
+// the date is read from the EXIF or the file modification time.
+func datePrefixKey(key string, content []byte, mtime time.Time) string {
+	if t, err := exifDate(content); err == nil && !t.IsZero() {
+		mtime = t
+	}
+	return path.Join("images", mtime.Format("2006"), mtime.Format("01"), path.Base(key))
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/h2non/bimg"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the linked libvips and the image formats it can load and save",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("libvips version: %s\n", bimg.VipsVersion)
+		fmt.Printf("bimg version: %s\n\n", bimg.Version)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(writer, "FORMAT\tLOAD\tSAVE")
+		for _, t := range []bimg.ImageType{bimg.JPEG, bimg.PNG, bimg.WEBP, bimg.AVIF, bimg.GIF, bimg.SVG, bimg.TIFF, bimg.HEIF, bimg.PDF, bimg.MAGICK} {
+			supported := bimg.IsImageTypeSupportedByVips(t)
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", bimg.ImageTypeName(t), yesOrNo(supported.Load), yesOrNo(supported.Save))
+		}
+		_ = writer.Flush()
+
+		fmt.Println()
+		if bimg.IsTypeSupported(bimg.MAGICK) {
+			fmt.Printf("The RAW camera formats (%s) are supported by the magick loader.\n", supportedRawFormats())
+		} else {
+			fmt.Printf("The RAW camera formats (%s) are unsupported, rebuild libvips with ImageMagick for enabling them.\n", supportedRawFormats())
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+func yesOrNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// DryRunReport collects the objects which would be written or deleted by a command with --dry-run.
+// The report is printed in the same format for all the commands, one object per line:
+//
+//	upload	images/2019/05/2019050902101874.jpg	102400
+//	total	1 objects	102400 bytes
+type DryRunReport struct {
+	lock    sync.Mutex
+	entries []dryRunEntry
+}
+
+type dryRunEntry struct {
+	action string
+	key    string
+	size   int64
+}
+
+// Add records the action on the object key with its size in bytes.
+func (r *DryRunReport) Add(action, key string, size int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries = append(r.entries, dryRunEntry{action: action, key: key, size: size})
+}
+
+// Print writes the entries sorted by key and the total counts.
+func (r *DryRunReport) Print(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	sort.Slice(r.entries, func(i, j int) bool { return r.entries[i].key < r.entries[j].key })
+
+	var total int64
+	for _, entry := range r.entries {
+		total += entry.size
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\n", entry.action, entry.key, entry.size)
+	}
+	_, _ = fmt.Fprintf(w, "total\t%d objects\t%d bytes\n", len(r.entries), total)
+}
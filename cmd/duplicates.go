@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"log"
+	"math/bits"
+	"strconv"
+
+	"github.com/h2non/bimg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	duplicatesCmd = &cobra.Command{
+		Use:   "duplicates",
+		Short: "Group the near-duplicate images by the perceptual hashes in the metadata file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if duplicatesDistance < 0 || duplicatesDistance > 64 {
+				return exitErrorf(ExitFailure, "Invalid distance %d, it should be between 0 and 64", duplicatesDistance)
+			}
+			config, err := ReadConfig()
+			if err != nil {
+				return err
+			}
+			if err := setupLogging(config); err != nil {
+				return err
+			}
+			client, err := newBucketClient(config)
+			if err != nil {
+				return err
+			}
+
+			metas, err := DownloadMetadata(client, config)
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to download the image metadata\nError: %v", err)
+			}
+
+			groups := GroupDuplicates(metas, duplicatesDistance)
+			for i, group := range groups {
+				if i > 0 {
+					fmt.Println()
+				}
+				for _, meta := range group {
+					fmt.Printf("%s\t%s\n", meta.PHash, meta.Slug)
+				}
+			}
+			log.Printf("Found %d groups of the near-duplicate images", len(groups))
+			return nil
+		},
+	}
+
+	duplicatesDistance = 6
+)
+
+func init() {
+	duplicatesCmd.Flags().IntVarP(&duplicatesDistance, "distance", "d", 6, "The max Hamming distance between the perceptual hashes of the duplicate images, from 0 to 64")
+	rootCmd.AddCommand(duplicatesCmd)
+}
+
+// perceptualHash computes the 64 bits difference hash (dHash) of the image in hex format. The image is
+// resized into 9x8 grayscale pixels, every bit means the pixel is brighter than its right neighbour.
+func perceptualHash(content []byte) (string, error) {
+	preview, err := bimg.NewImage(content).Process(bimg.Options{Width: 9, Height: 8, Force: true, Type: bimg.PNG})
+	if err != nil {
+		return "", err
+	}
+	decoded, err := png.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return "", err
+	}
+
+	bounds := decoded.Bounds()
+	if bounds.Dx() != 9 || bounds.Dy() != 8 {
+		return "", fmt.Errorf("the hash preview is %dx%d instead of 9x8", bounds.Dx(), bounds.Dy())
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(decoded.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(decoded.At(bounds.Min.X+x+1, bounds.Min.Y+y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// GroupDuplicates groups the images whose perceptual hashes are within the Hamming distance.
+// The groups are connected, two images in a group may be farther than the distance through the others.
+// The images without the perceptual hash and the unique images are omitted.
+func GroupDuplicates(metas []ImageMetadata, distance int) [][]ImageMetadata {
+	var hashed []ImageMetadata
+	var hashes []uint64
+	for _, meta := range metas {
+		if hash, err := strconv.ParseUint(meta.PHash, 16, 64); err == nil {
+			hashed = append(hashed, meta)
+			hashes = append(hashes, hash)
+		}
+	}
+
+	// Union find the images within the distance.
+	parents := make([]int, len(hashed))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if bits.OnesCount64(hashes[i]^hashes[j]) <= distance {
+				parents[find(i)] = find(j)
+			}
+		}
+	}
+
+	members := map[int][]ImageMetadata{}
+	var roots []int
+	for i, meta := range hashed {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], meta)
+	}
+	var groups [][]ImageMetadata
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
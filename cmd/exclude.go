@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// SyncIgnoreFile is the file at the project root with the exclude patterns, one pattern per line.
+const SyncIgnoreFile = ".syncignore"
+
+var (
+	excludeGlobs  []string
+	useSyncIgnore = false
+	// excludePatterns are the parsed --exclude and .syncignore patterns, every pattern is split by the slash.
+	excludePatterns [][]string
+)
+
+func init() {
+	// The check command skips the same files as the sync.
+	for _, cmd := range []*cobra.Command{syncCmd, checkCmd} {
+		cmd.Flags().StringArrayVarP(&excludeGlobs, "exclude", "", nil, "Skip the files whose keys relative to the project root match the glob pattern like images/drafts/** or *.psd, it's repeatable")
+		cmd.Flags().BoolVarP(&useSyncIgnore, "syncignore", "", false, "Also skip the files matching the patterns in the "+SyncIgnoreFile+" file at the project root")
+	}
+}
+
+// loadExcludePatterns parses the --exclude patterns and the .syncignore patterns if it's enabled.
+// The missing .syncignore file is treated as empty.
+func loadExcludePatterns(root string) error {
+	patterns := excludeGlobs
+	if useSyncIgnore {
+		content, err := os.ReadFile(filepath.Join(root, SyncIgnoreFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+
+	excludePatterns = nil
+	for _, pattern := range patterns {
+		segments, err := parseExcludePattern(pattern)
+		if err != nil {
+			return err
+		}
+		excludePatterns = append(excludePatterns, segments)
+	}
+	return nil
+}
+
+// parseExcludePattern splits the glob pattern into the path segments. The pattern without a slash matches
+// the file or directory name at any depth like the .gitignore, the others are anchored at the project root.
+func parseExcludePattern(pattern string) ([]string, error) {
+	trimmed := strings.Trim(strings.TrimSpace(pattern), "/")
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid exclude pattern %q, it's empty", pattern)
+	}
+	segments := strings.Split(trimmed, "/")
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %v", pattern, err)
+		}
+	}
+	if !strings.Contains(trimmed, "/") {
+		segments = append([]string{"**"}, segments...)
+	}
+	return segments, nil
+}
+
+// isExcludedKey checks the slash separated path relative to the project root against the exclude patterns.
+// The file under the matched directory is excluded with its whole subtree.
+func isExcludedKey(key string) bool {
+	segments := strings.Split(key, "/")
+	for _, pattern := range excludePatterns {
+		if matchExcludePattern(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExcludePattern matches the pattern against the leading segments, the ** matches zero or more segments.
+func matchExcludePattern(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchExcludePattern(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchExcludePattern(pattern[1:], segments[1:])
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// The exit codes of the failure classes for the automation scripts.
+const (
+	// ExitFailure means the command failed completely, it's also used for the unclassified errors.
+	ExitFailure = 1
+	// ExitConfig means the config file or the settings in it are invalid.
+	ExitConfig = 2
+	// ExitAuth means the S3 credentials are missing or rejected.
+	ExitAuth = 3
+	// ExitPartial means some files failed to be uploaded or converted while the others succeeded.
+	ExitPartial = 4
+)
+
+// ExitError is returned by the commands for exiting with the code of its failure class.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitErrorf creates the ExitError of the failure class with the formatted message.
+func exitErrorf(code int, format string, v ...any) *ExitError {
+	return &ExitError{Code: code, Err: fmt.Errorf(format, v...)}
+}
+
+// exitCode returns the exit code of the error returned by the command, the unclassified errors are ExitFailure.
+func exitCode(err error) int {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return ExitFailure
+}
+
+// authErrorCodes are the S3 error codes for the invalid credentials.
+var authErrorCodes = map[string]struct{}{
+	"InvalidAccessKeyId":    {},
+	"SignatureDoesNotMatch": {},
+	"AccessDenied":          {},
+	"ExpiredToken":          {},
+	"InvalidToken":          {},
+}
+
+// isAuthError checks the S3 error is caused by the credentials. The HEAD requests have no error code,
+// the 403 status is used instead.
+func isAuthError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if _, ok := authErrorCodes[apiErr.ErrorCode()]; ok {
+			return true
+		}
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusForbidden
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// formatOutput is the image encoded in one of the extra formats.
+type formatOutput struct {
+	Format string
+	Bytes  []byte
+	Err    error
+}
+
+// validateExtraFormats checks the extra formats could be saved by libvips besides the main format.
+func validateExtraFormats(formats []string, main string) error {
+	for _, format := range formats {
+		if _, ok := supportExtensions[format]; !ok {
+			return fmt.Errorf("unsupported extra format %s, only supports %s", format, supportedFormats())
+		}
+		if format == SVG || imageType(format) == bimg.UNKNOWN || !bimg.IsTypeSupportedSave(imageType(format)) {
+			return fmt.Errorf("the extra format %s couldn't be saved by the linked libvips", format)
+		}
+		if imageType(format) == imageType(main) {
+			return fmt.Errorf("the extra format %s is the same as the --format %s", format, main)
+		}
+	}
+	return nil
+}
+
+// formatName replaces the extension of the file name with the format, like image.avif.
+func formatName(name, format string) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + "." + format
+}
+
+// encodeFormats encodes the source into the extra formats in the background. The source is resized into
+// a lossless intermediate once, every format is encoded from it in a worker group of the given concurrency,
+// 0 for the number of CPU cores.
+// Every worker holds its own bimg image, the processing of bimg replaces the buffer of the image.
+// The returned function waits for all the outputs in the order of the formats.
+func encodeFormats(source []byte, options bimg.Options, formats []string, quality, concurrency int) func() []formatOutput {
+	outputs := make([]formatOutput, len(formats))
+	if len(formats) == 0 {
+		return func() []formatOutput { return outputs }
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		intermediate := options
+		intermediate.Type = bimg.PNG
+		intermediate.Interlace = false
+		resized, err := bimg.NewImage(source).Process(intermediate)
+		save := vipsSaveOptions{Copyright: imageCopyright, Author: imageAuthor}
+		exif := save != (vipsSaveOptions{})
+
+		if concurrency == 0 {
+			concurrency = runtime.NumCPU()
+		}
+		tokens := make(chan struct{}, concurrency)
+		for i, format := range formats {
+			outputs[i].Format = format
+			if err != nil {
+				outputs[i].Err = err
+				continue
+			}
+			wg.Add(1)
+			tokens <- struct{}{}
+			go func(i int, format string) {
+				defer func() {
+					<-tokens
+					wg.Done()
+				}()
+				start := time.Now()
+				interlace := imageProgressive && isInterlaced(format)
+				if exif {
+					save := save
+					save.Interlace = interlace
+					outputs[i].Bytes, outputs[i].Err = vipsSave(resized, format, quality, save)
+				} else {
+					outputs[i].Bytes, outputs[i].Err = bimg.NewImage(resized).Process(bimg.Options{Type: imageType(format), Quality: quality, Interlace: interlace})
+				}
+				debugf("Encode the %s image in %v", format, time.Since(start).Round(time.Millisecond))
+			}(i, format)
+		}
+	}()
+	return func() []formatOutput {
+		wg.Wait()
+		return outputs
+	}
+}
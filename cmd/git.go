@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// gitChangedFiles lists the files in the synced directories changed since the git ref, the paths are
+// relative to the project root. The deleted files are returned separately for pruning their objects.
+func gitChangedFiles(root, ref string, directories []string) (changed, deleted []string, err error) {
+	if out, e := exec.Command("git", "-C", root, "rev-parse", "--git-dir").CombinedOutput(); e != nil {
+		return nil, nil, fmt.Errorf("the project root %s isn't in a git repository: %s", root, strings.TrimSpace(string(out)))
+	}
+	args := append([]string{"-C", root, "diff", "--name-status", "--no-renames", "--relative", ref, "--"}, directories...)
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to diff the files since %s: %s", ref, strings.TrimSpace(stderr.String()))
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		status, file, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		if status == "D" {
+			deleted = append(deleted, file)
+		} else {
+			changed = append(changed, file)
+		}
+	}
+	return changed, deleted, scanner.Err()
+}
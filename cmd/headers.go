@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HeaderRule is a group of the response headers for the paths matching the pattern, like /images/*.
+type HeaderRule struct {
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// renderHeadersFile generates the _headers file used by Cloudflare Pages and Netlify.
+// Every rule is a path line followed by the indented header lines.
+func renderHeadersFile(rules []HeaderRule) ([]byte, error) {
+	var builder strings.Builder
+	for i, rule := range rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("invalid header rule path %q, it should start with /", rule.Path)
+		}
+		if len(rule.Headers) == 0 {
+			return nil, fmt.Errorf("no headers is provided for the path %s", rule.Path)
+		}
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(rule.Path + "\n")
+		for _, name := range sortedKeys(rule.Headers) {
+			builder.WriteString(fmt.Sprintf("  %s: %s\n", name, rule.Headers[name]))
+		}
+	}
+	return []byte(builder.String()), nil
+}
+
+// UploadHeadersFile uploads the configured header rules into the sync.headersFile in the bucket root.
+func UploadHeadersFile(client *BucketClient, config *PandoraConfig) {
+	if config.Sync.HeadersFile == "" {
+		return
+	}
+
+	content, err := renderHeadersFile(config.Sync.Headers)
+	if err != nil {
+		errorf("Failed to render the headers file.\nError: %v", err)
+		return
+	}
+	key := strings.TrimPrefix(config.Sync.HeadersFile, "/")
+	if err = client.UploadObject(operationContext, key, content, nil); err != nil {
+		errorf("Failed to upload the headers file %s", key)
+		return
+	}
+	successf("Successfully upload the headers file %s with %d rules", key, len(config.Sync.Headers))
+}
@@ -2,18 +2,27 @@ package cmd
 
 import (
 	"bufio"
-	"context"
+	"bytes"
 	"fmt"
+	"image/color"
+	"image/png"
 	"io"
+	"io/fs"
 	"log"
+	"math"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/h2non/bimg"
+	"github.com/qingstor/go-mime"
 	"github.com/spf13/cobra"
 	"golang.design/x/clipboard"
 )
@@ -28,6 +37,17 @@ const (
 	APNG = "apng"
 	SVG  = "svg"
 	BMP  = "bmp"
+
+	DNG = "dng"
+	CR2 = "cr2"
+	NEF = "nef"
+
+	// AutoWidth is the width left unset, it's computed from the --height for keeping the ratio,
+	// or it's the DefaultImageWidth if the height is unset too.
+	AutoWidth         = 0
+	DefaultImageWidth = 1280
+	// MaxAspectRatio bounds the --aspect, the ratio should be between 1:100 and 100:1.
+	MaxAspectRatio = 100
 )
 
 var supportExtensions = map[string]struct{}{
@@ -42,14 +62,81 @@ var supportExtensions = map[string]struct{}{
 	BMP:  {},
 }
 
+// contentTypes are the MIME types of the uploaded images and metadata, the other files are detected by go-mime.
+var contentTypes = map[string]string{
+	JPEG:   "image/jpeg",
+	JPG:    "image/jpeg",
+	PNG:    "image/png",
+	AVIF:   "image/avif",
+	WEBP:   "image/webp",
+	GIF:    "image/gif",
+	APNG:   "image/apng",
+	SVG:    "image/svg+xml; charset=utf-8",
+	BMP:    "image/bmp",
+	"json": "application/json",
+}
+
+// contentType detects the MIME type of the object from its key extension. The browsers download the
+// object in the default binary/octet-stream instead of rendering it.
+func contentType(key string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(key), "."))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	return mime.DetectFileExt(ext)
+}
+
+// rawExtensions are the camera RAW formats, which could only be used as the source image.
+// They are loaded by the libvips magick loader.
+var rawExtensions = map[string]struct{}{
+	DNG: {},
+	CR2: {},
+	NEF: {},
+}
+
 func init() {
-	imageCmd.Flags().StringVarP(&imageSource, "source", "s", "", "The image file path (absolute of relative)")
-	imageCmd.Flags().IntVarP(&width, "width", "", 1280, "The resized image width")
-	imageCmd.Flags().IntVarP(&height, "height", "", 0, "The optional image height, 0 for keep ratio")
-	imageCmd.Flags().StringVarP(&imageLocalDate, "time", "t", imageLocalDate, "The date time, in 20060102 format")
-	imageCmd.Flags().StringVarP(&imageFormat, "format", "f", JPG, "The image format")
-	imageCmd.Flags().IntVarP(&imageQuality, "quality", "q", 0, "The image quality")
+	imageCmd.Flags().StringVarP(&imageSource, "source", "s", "", "The image file or directory path (absolute of relative), every image in the directory is converted")
+	imageCmd.Flags().BoolVarP(&imageRecursive, "recursive", "r", false, "Convert the images in the subdirectories of the directory --source")
+	imageCmd.Flags().BoolVarP(&imageContinueOnError, "continue-on-error", "", true, "Skip the failed images of the directory --source, false for stopping at the first failure")
+	imageCmd.Flags().IntVarP(&width, "width", "", AutoWidth, "The resized image width, 0 for computing it from --height or 1280 if both are unset")
+	imageCmd.Flags().IntVarP(&height, "height", "", 0, "The optional image height, 0 for keep ratio. The image is cropped if the --width is also given")
+	imageCmd.Flags().StringVarP(&imageLocalDate, "time", "t", TimeNow, "The date time, one of now, exif, filename or in 20060102 format")
+	imageCmd.Flags().StringVarP(&imageFormat, "format", "f", "", "The image format, default to the convert.defaultFormat in config or jpg")
+	imageCmd.Flags().BoolVarP(&imageForceFormat, "force-format", "", false, "Fail if the image couldn't be saved in the --format instead of falling back to jpg")
+	imageCmd.Flags().StringSliceVarP(&imageExtraFormats, "extra-formats", "", nil, "Also save the image in the extra formats with the same name, like avif,webp")
+	imageCmd.Flags().IntVarP(&imageConcurrency, "encode-concurrency", "", 0, "The max number of the extra formats encoded in parallel, 0 for the number of CPU cores")
+	imageCmd.Flags().IntVarP(&imageQuality, "quality", "q", 0, "The image quality from 1 to 100, 0 for the convert.defaultQuality in config")
 	imageCmd.Flags().BoolVarP(&uploadImage, "upload", "", true, "Whether to upload image")
+	imageCmd.Flags().BoolVarP(&outputAdjacent, "output-adjacent", "", false, "Save the image next to the source file instead of the dated directory")
+	imageCmd.Flags().StringVarP(&imageAspect, "aspect", "", "", "The target aspect ratio like 16:9, the height is computed from width and the image is cropped, between 1:100 and 100:1")
+	imageCmd.Flags().StringVarP(&imageGravity, "gravity", "", "centre", "The crop gravity, one of "+supportedGravities()+", the smart keeps the most interesting area")
+	imageCmd.Flags().StringVarP(&imageFocal, "focal", "", "", "The focal point x,y in fractions like 0.3,0.6 kept in the cropped image, it overrides the --gravity")
+	imageCmd.Flags().BoolVarP(&imageNormalize, "normalize", "", false, "Stretch the image histogram for auto-leveling the contrast")
+	imageCmd.Flags().BoolVarP(&imageIfNewer, "if-newer", "", false, "Only overwrite the existing target image when the source is newer")
+	imageCmd.Flags().BoolVarP(&imageForce, "force", "", false, "Always overwrite the existing target image and re-convert the already optimized source")
+	imageCmd.Flags().BoolVarP(&imageStdout, "stdout", "", false, "Write the processed image to stdout without saving and uploading")
+	imageCmd.Flags().Float64VarP(&imageDensity, "density", "", 0, "The DPI for rasterizing the SVG source, 0 for matching the target width")
+	imageCmd.Flags().IntVarP(&imageMaxBytes, "max-bytes", "", 0, "The target file size in bytes, the quality is lowered for fitting it, 0 for no limit")
+	imageCmd.Flags().IntVarP(&imageMinQuality, "min-quality", "", MinQuality, "The lowest quality allowed for fitting the --max-bytes or --target-ssim")
+	imageCmd.Flags().Float64VarP(&imageTargetSSIM, "target-ssim", "", 0, "Use the lowest quality whose SSIM against the source reaches the target like 0.98, 0 for the fixed quality")
+	imageCmd.Flags().BoolVarP(&imageDeleteSource, "delete-source", "", false, "Delete the source image after it's converted successfully")
+	imageCmd.Flags().StringVarP(&imageCopyright, "copyright", "", "", "Write the copyright into the EXIF metadata of the converted image")
+	imageCmd.Flags().BoolVarP(&imageStripMetadata, "strip-metadata", "", true, "Strip the EXIF, GPS and XMP metadata from the converted image, the EXIF orientation is applied to the pixels first")
+	imageCmd.Flags().StringVarP(&imageAuthor, "author", "", "", "Write the author into the EXIF metadata of the converted image")
+	imageCmd.Flags().BoolVarP(&imageOptimizePNG, "optimize-png", "", false, "Save the png in the max compression, it's slower and reports the size reduction")
+	imageCmd.Flags().IntVarP(&imagePNGColors, "png-colors", "", 0, "Quantize the --optimize-png image into a palette of the colors from 2 to 256, 0 for keeping the full colors")
+	imageCmd.Flags().BoolVarP(&imageProgressive, "progressive", "", false, "Save the progressive jpg or the interlaced png, which is rendered gradually on the slow connections")
+	imageCmd.Flags().BoolVarP(&imageNoSubsample, "no-subsample", "", false, "Keep the full 4:4:4 chroma for the sharp colored text, it only affects the jpg output")
+	imageCmd.Flags().StringVarP(&imageOutputName, "output-name", "", "", "The base file name of the target image without extension, the extension is the --format")
+	imageCmd.Flags().IntSliceVarP(&imageSizes, "sizes", "", nil, "Generate the responsive images in the widths like 480,768,1280, the srcset is copied into clipboard")
+	imageCmd.Flags().BoolVarP(&imageRetina, "retina", "", false, "Generate an extra @2x image in double width for the high DPI screens")
+	imageCmd.Flags().StringVarP(&imagePreset, "preset", "p", "", "The conversion preset in config, the given flags override the preset")
+	imageCmd.Flags().BoolVarP(&imageOG, "og", "", false, "Generate the open graph image in exactly the convert.og size for the social previews, 1200x630 by default")
+	imageCmd.Flags().StringVarP(&imageCaption, "caption", "", "", "The caption text drawn on the --og image")
+	imageCmd.Flags().DurationVarP(&imageExpires, "expires", "", 0, "Set the HTTP Expires header of the uploaded image to now plus the duration like 720h, it doesn't delete it")
+	imageCmd.Flags().StringVarP(&imageManifestFile, "manifest", "", "", "Write the generated variants with their sizes, links and the blur placeholder into the JSON file, - for stdout")
+	imageCmd.Flags().StringVarP(&imageReport, "report", "", "", "Write the source, output, link and size of the generated image into the JSON or .jsonl file")
+	imageCmd.Flags().IntVarP(&imageMaxDimension, "max-dimension", "", 0, "The max size of the longest side, 0 for the convert.maxDimension in config")
 
 	err := imageCmd.MarkFlagRequired("source")
 	if err != nil {
@@ -64,64 +151,444 @@ var (
 	imageCmd = &cobra.Command{
 		Use:   "image",
 		Short: "A tool for processing images to my desired format, size and naming",
-		Run: func(cmd *cobra.Command, args []string) {
-			config := ReadConfig()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := ReadValidConfig(uploadImage && !imageStdout)
+			if err != nil {
+				return err
+			}
+			if err := setupLogging(config); err != nil {
+				return err
+			}
+			if err := setupVips(config); err != nil {
+				return err
+			}
+			blurFormat = config.Metadata.BlurFormat
+			if imageFormat == "" {
+				imageFormat = config.Convert.DefaultFormat
+			}
+			if imageFormat == "" {
+				imageFormat = JPG
+			}
+
+			if imagePreset != "" {
+				preset, ok := config.Presets[imagePreset]
+				if !ok {
+					return exitErrorf(ExitConfig, "The preset %s doesn't exist in config, available presets: %s", imagePreset, strings.Join(sortedKeys(config.Presets), ", "))
+				}
+				applyPreset(cmd, preset)
+				debugf("Use the preset %s: width %d, height %d, format %s, quality %d, aspect %q, gravity %s, normalize %v, max bytes %d, max dimension %d",
+					imagePreset, width, height, imageFormat, imageQuality, imageAspect, imageGravity, imageNormalize, imageMaxBytes, imageMaxDimension)
+			}
 
 			// Check the image source path is valid.
 			info, err := os.Stat(imageSource)
 			if err != nil {
-				log.Fatalf("Couldn't read the given file from the path %s, err: %v", imageSource, err)
+				return exitErrorf(ExitFailure, "Couldn't read the given file from the path %s, err: %v", imageSource, err)
 			}
 
+			// The images in the directory are converted in the same settings.
+			sources := []string{imageSource}
 			if info.IsDir() {
-				log.Fatalf("The given path %s is a directory. Only image is accepted", imageSource)
+				if imageStdout || imageOutputName != "" || imageOG || imageManifestFile != "" {
+					return exitErrorf(ExitFailure, "The directory source couldn't be used with --stdout, --output-name, --og or --manifest")
+				}
+				if sources, err = listImages(imageSource, imageRecursive); err != nil {
+					return exitErrorf(ExitFailure, "Failed to read the directory %s\nError: %v", imageSource, err)
+				}
+				if len(sources) == 0 {
+					return exitErrorf(ExitFailure, "No supported image is found in the directory %s", imageSource)
+				}
+				infof("Found %d images in the directory %s", len(sources), imageSource)
+			} else if ok, ext := isSupportedImage(info.Name()); !ok {
+				if !isRawImage(info.Name()) {
+					return exitErrorf(ExitFailure, "Unsupported file extension %s. Allowed extensions: %s, %s", ext, supportedFormats(), supportedRawFormats())
+				}
+				if !bimg.IsTypeSupported(bimg.MAGICK) {
+					return exitErrorf(ExitFailure, "The RAW image %s requires libvips built with the ImageMagick loader.\n"+
+						`Execute the command "pandora doctor" for checking the supported formats.`, ext)
+				}
 			}
 
-			if ok, ext := isSupportedImage(info.Name()); !ok {
-				log.Fatalf("Unsupported file extension %s. Allowed extensions: %s", ext, supportedFormats())
+			// File convert format check.
+			imageFormat = strings.ToLower(imageFormat)
+			if _, ok := supportExtensions[imageFormat]; !ok {
+				return exitErrorf(ExitFailure, "Invalid convert format, only supports %s", supportedFormats())
 			}
 
-			// Get the file operand
-			img, err := os.Open(imageSource)
-			if err != nil {
-				log.Fatalf("Failed to read image %v", err)
+			// The format without the libvips saver is converted into JPEG unless it's forced.
+			if imageType(imageFormat) == bimg.UNKNOWN {
+				if imageForceFormat {
+					return exitErrorf(ExitFailure, "The %s format couldn't be saved by libvips, choose another --format", imageFormat)
+				}
+				warnf("The %s format couldn't be saved by libvips, save the image in %s instead. Use --force-format for failing on it", imageFormat, JPG)
+				imageFormat = JPG
+			}
+			if imageForceFormat && imageFormat != SVG && !bimg.IsTypeSupportedSave(imageType(imageFormat)) {
+				return exitErrorf(ExitFailure, "The linked libvips couldn't save the %s format.\n"+
+					`Execute the command "pandora doctor" for checking the supported formats.`, imageFormat)
 			}
 
-			// File convert format check.
-			if _, ok := supportExtensions[imageFormat]; !ok {
-				log.Fatalf("Invalid convert format, only supports %s", supportedFormats())
+			// Check the time mode or pattern is valid.
+			if !isValidTimeMode(imageLocalDate) {
+				if !imageLocalDatePattern.Match([]byte(imageLocalDate)) {
+					return exitErrorf(ExitFailure, "This is an invalid local date format %s, it should be %s or yyyyMMdd", imageLocalDate, strings.Join(timeModes, ", "))
+				}
+				if _, err := time.Parse("20060102", imageLocalDate); err != nil {
+					return exitErrorf(ExitFailure, `Invalid time str %v. It should be "yyyyMMdd"" like %v`, imageLocalDate, time.Now().Format("20060102"))
+				}
 			}
 
-			// Check the time pattern is valid.
-			if !imageLocalDatePattern.Match([]byte(imageLocalDate)) {
-				log.Fatalf("This is an invalid local date format %s", imageLocalDate)
+			if (imageCopyright != "" || imageAuthor != "") && (imageFormat == SVG || imageFormat == GIF) {
+				return exitErrorf(ExitFailure, "The %s format doesn't support the --copyright and --author", imageFormat)
 			}
-			t, err := time.Parse("20060102", imageLocalDate)
-			if err != nil {
-				log.Fatalf(`Invalid time str %v. It should be "yyyyMMdd"" like %v`, imageLocalDate, time.Now().Format("20060102"))
+			if imageOutputName != "" && (strings.ContainsAny(imageOutputName, `/\`) || imageOutputName == "." || imageOutputName == "..") {
+				return exitErrorf(ExitFailure, "Invalid output name %s, it should be a file name without the path separators", imageOutputName)
+			}
+			if imageManifestFile == "-" && imageStdout {
+				return exitErrorf(ExitFailure, "The --manifest couldn't be printed to stdout with --stdout")
+			}
+			if imageRetina && (imageStdout || imageFormat == SVG) {
+				return exitErrorf(ExitFailure, "The --retina couldn't be used with --stdout or the svg format")
+			}
+			if imageTargetSSIM != 0 {
+				if imageTargetSSIM < 0 || imageTargetSSIM > 1 {
+					return exitErrorf(ExitFailure, "Invalid target SSIM %v, it should be between 0 and 1", imageTargetSSIM)
+				}
+				if imageMaxBytes > 0 {
+					return exitErrorf(ExitFailure, "The --target-ssim and --max-bytes couldn't be used together")
+				}
+				if t := imageType(imageFormat); t != bimg.JPEG && t != bimg.WEBP && t != bimg.AVIF {
+					return exitErrorf(ExitFailure, "The --target-ssim only supports the lossy formats jpg, webp and avif")
+				}
+			}
+			if imageProgressive && !isInterlaced(imageFormat) {
+				warnf("The --progressive only applies to the jpg and png formats, it's ignored for the %s format", imageFormat)
+			}
+			if imageOptimizePNG && imageType(imageFormat) != bimg.PNG {
+				return exitErrorf(ExitFailure, "The --optimize-png only works with the png format")
+			}
+			if imagePNGColors != 0 && (!imageOptimizePNG || imagePNGColors < 2 || imagePNGColors > 256) {
+				return exitErrorf(ExitFailure, "Invalid png colors %d, it should be between 2 and 256 with the --optimize-png", imagePNGColors)
+			}
+			if imageMaxBytes < 0 {
+				return exitErrorf(ExitFailure, "Invalid max bytes %d, it should be a positive number", imageMaxBytes)
+			}
+			if imageExpires < 0 {
+				return exitErrorf(ExitFailure, "Invalid expires %v, it should be a positive duration", imageExpires)
+			}
+			if imageDensity < 0 {
+				return exitErrorf(ExitFailure, "Invalid density %v, it should be a positive number", imageDensity)
+			}
+
+			// The open graph image is cover cropped into the exact size.
+			if imageOG {
+				if imageAspect != "" || cmd.Flags().Changed("width") || cmd.Flags().Changed("height") {
+					return exitErrorf(ExitFailure, "The --og couldn't be used with --width, --height or --aspect, set the size in convert.og of config")
+				}
+				if imageRetina || outputAdjacent || imageFormat == SVG {
+					return exitErrorf(ExitFailure, "The --og couldn't be used with --retina, --output-adjacent or the svg format")
+				}
+				width, height = ogSize(config)
+				// The predictable name is derived from the source, like cover-og.jpg.
+				if imageOutputName == "" {
+					imageOutputName = strings.TrimSuffix(info.Name(), filepath.Ext(info.Name())) + "-og"
+				}
+			} else if imageCaption != "" {
+				return exitErrorf(ExitFailure, "The --caption only works with --og")
+			}
+
+			if width < 0 || height < 0 {
+				return exitErrorf(ExitFailure, "Invalid image size %dx%d, the width and height should be positive numbers", width, height)
+			}
+			// The width is only computed from the height, the default width is used without the height.
+			if width == AutoWidth && (height == 0 || imageAspect != "") {
+				width = DefaultImageWidth
+			}
+
+			// The responsive images are resized into every width, the height keeps the ratio or the --aspect.
+			if len(imageSizes) > 0 {
+				if cmd.Flags().Changed("width") || cmd.Flags().Changed("height") || imageOG || imageRetina || imageStdout {
+					return exitErrorf(ExitFailure, "The --sizes couldn't be used with --width, --height, --og, --retina or --stdout")
+				}
+				if imageSizes, err = normalizeSizes(imageSizes); err != nil {
+					return exitErrorf(ExitFailure, "Invalid sizes\nError: %v", err)
+				}
+			}
+
+			// Compute the height from the aspect ratio.
+			ratio := 0.0
+			if imageAspect != "" {
+				if cmd.Flags().Changed("height") {
+					return exitErrorf(ExitFailure, "The --aspect and --height flags couldn't be used together")
+				}
+				var e error
+				if ratio, e = parseAspectRatio(imageAspect); e != nil {
+					return exitErrorf(ExitFailure, "Invalid aspect ratio %s\nError: %v", imageAspect, e)
+				}
+				height = int(math.Round(float64(width) / ratio))
+			}
+			if _, ok := gravities[imageGravity]; !ok {
+				return exitErrorf(ExitFailure, "Invalid gravity %s, only supports %s", imageGravity, supportedGravities())
+			}
+			if imageGravity != "centre" && (height == 0 || width == AutoWidth) {
+				warnf("The --gravity %s is ignored, the image is only cropped with both --width and --height, --aspect or --og", imageGravity)
+			}
+			if imageFocal != "" {
+				var e error
+				if focalX, focalY, e = parseFocalPoint(imageFocal); e != nil {
+					return exitErrorf(ExitFailure, "Invalid focal point %s\nError: %v", imageFocal, e)
+				}
+				if height == 0 || width == AutoWidth {
+					return exitErrorf(ExitFailure, "The --focal only works with the cropping by --width and --height, --aspect or --og")
+				}
 			}
 
 			if imageQuality == 0 {
 				imageQuality = config.Convert.DefaultQuality
 			}
-			if imageFormat == "" {
-				imageFormat = config.Convert.DefaultFormat
+			if !isValidQuality(imageQuality) {
+				return exitErrorf(ExitFailure, "Invalid image quality %d, it should be between %d and %d", imageQuality, MinQuality, MaxQuality)
+			}
+			if !isValidQuality(imageMinQuality) || imageMinQuality > imageQuality {
+				return exitErrorf(ExitFailure, "Invalid min quality %d, it should be between %d and the image quality %d", imageMinQuality, MinQuality, imageQuality)
+			}
+			if len(imageExtraFormats) > 0 {
+				if imageStdout || imageFormat == SVG {
+					return exitErrorf(ExitFailure, "The --extra-formats couldn't be used with --stdout or the svg format")
+				}
+				if err := validateExtraFormats(imageExtraFormats, imageFormat); err != nil {
+					return exitErrorf(ExitFailure, "Invalid extra formats %s\nError: %v", strings.Join(imageExtraFormats, ","), err)
+				}
+				if imageConcurrency < 0 {
+					return exitErrorf(ExitFailure, "Invalid encode concurrency %d, it should be a positive number", imageConcurrency)
+				}
+			}
+			if hasWatermark() {
+				if imageFormat == SVG {
+					return exitErrorf(ExitFailure, "The svg format doesn't support the watermark")
+				}
+				if err := loadWatermark(); err != nil {
+					return exitErrorf(ExitFailure, "Invalid watermark\nError: %v", err)
+				}
+			}
+			if imageMaxDimension == 0 {
+				imageMaxDimension = config.Convert.MaxDimension
+			}
+			if imageMaxDimension < 0 {
+				return exitErrorf(ExitFailure, "Invalid max dimension %d, it should be a positive number", imageMaxDimension)
 			}
 
-			process(img, width, height, t, config)
+			if imageReport != "" {
+				linkReport = &LinkReport{}
+			}
+			if imageManifestFile != "" {
+				imageManifest = &ImageManifest{}
+			}
+			// The failed image of the directory source is skipped unless it's the fail-fast mode.
+			batch := &imageBatch{}
+			for _, source := range sources {
+				target, err := convertSource(source, ratio, config)
+				// The source is deleted right after it's converted, the later failure of the batch never keeps it.
+				if err == nil && imageDeleteSource && target != "" {
+					err = deleteSource(source, target)
+				}
+				// The remaining images are never converted after the --timeout.
+				if err != nil && (!info.IsDir() || !imageContinueOnError || exitCode(err) == ExitAuth || operationContext.Err() != nil) {
+					if info.IsDir() {
+						batch.Fail(source, err)
+						batch.Print()
+					}
+					// The report keeps the failure and the images converted before it.
+					if e := linkReport.Write(imageReport); e != nil {
+						errorf("Failed to write the report %s\nError: %v", imageReport, e)
+					}
+					return err
+				}
+				if err != nil {
+					errorf("Failed to process the image %s, skip it.\nError: %v", source, err)
+					batch.Fail(source, err)
+					continue
+				}
+				batch.converted++
+			}
+			if err := linkReport.Write(imageReport); err != nil {
+				return exitErrorf(ExitFailure, "Failed to write the report %s\nError: %v", imageReport, err)
+			}
+			if err := imageManifest.Write(imageManifestFile); err != nil {
+				return exitErrorf(ExitFailure, "Failed to write the manifest %s\nError: %v", imageManifestFile, err)
+			}
+			// Save the links into clipboard, one link per line for the directory source.
+			if len(imageLinks) > 0 {
+				copyToClipboard(strings.Join(imageLinks, "\n"))
+			}
+
+			if info.IsDir() {
+				batch.Print()
+			}
+			return batch.Err()
 		},
 	}
 
-	width                 = 1280
+	width                 = AutoWidth
 	height                = 0
 	imageSource           = ""
-	imageLocalDate        = time.Now().Format("20060102")
+	imageLocalDate        = TimeNow
 	imageLocalDatePattern = regexp.MustCompile(`^\d{8}$`)
 	imageFormat           = ""
 	imageQuality          = 0
 	uploadImage           = true
+	outputAdjacent        = false
+	imageAspect           = ""
+	imageGravity          = "centre"
+	imageMaxDimension     = 0
+	imageNormalize        = false
+	imageIfNewer          = false
+	imageForce            = false
+	imageStdout           = false
+	imageDensity          = 0.0
+	imageMaxBytes         = 0
+	imageMinQuality       = MinQuality
+	imageDeleteSource     = false
+	imagePreset           = ""
+	imageCopyright        = ""
+	imageOutputName       = ""
+	imageRetina           = false
+	imageSizes            []int
+	imageTargetSSIM       = 0.0
+	imageNoSubsample      = false
+	imageProgressive      = false
+	imageAuthor           = ""
+	imageStripMetadata    = true
+	imageOG               = false
+	imageCaption          = ""
+	imageExpires          time.Duration
+	imageFocal            = ""
+	imageReport           = ""
+	imageForceFormat      = false
+	imageOptimizePNG      = false
+	imageManifestFile     = ""
+	imagePNGColors        = 0
+	imageExtraFormats     []string
+	imageConcurrency      = 0
+	imageRecursive        = false
+	imageContinueOnError  = true
+	focalX, focalY        float64
+	// imageLinks are the links of the uploaded images, they are copied into clipboard at the end.
+	imageLinks []string
+	// sizesBase is the shared base name of the --sizes images converted from the same source.
+	sizesBase string
+
+	gravities = map[string]bimg.Gravity{
+		"centre": bimg.GravityCentre,
+		"center": bimg.GravityCentre,
+		"north":  bimg.GravityNorth,
+		"south":  bimg.GravitySouth,
+		"east":   bimg.GravityEast,
+		"west":   bimg.GravityWest,
+		// The libvips smartcrop keeps the most interesting area by the attention strategy,
+		// which looks for the skin tones, the saturated colors and the edges.
+		"smart": bimg.GravitySmart,
+	}
 )
 
+// applyPreset fills the options from the preset unless the flags are given explicitly.
+func applyPreset(cmd *cobra.Command, preset ImagePreset) {
+	flags := cmd.Flags()
+	if preset.Width != 0 && !flags.Changed("width") {
+		width = preset.Width
+	}
+	if preset.Height != 0 && !flags.Changed("height") {
+		height = preset.Height
+	}
+	if preset.Format != "" && !flags.Changed("format") {
+		imageFormat = preset.Format
+	}
+	if preset.Quality != 0 && !flags.Changed("quality") {
+		imageQuality = preset.Quality
+	}
+	if preset.Aspect != "" && !flags.Changed("aspect") && !flags.Changed("height") {
+		imageAspect = preset.Aspect
+	}
+	if preset.Gravity != "" && !flags.Changed("gravity") {
+		imageGravity = preset.Gravity
+	}
+	if preset.Normalize && !flags.Changed("normalize") {
+		imageNormalize = true
+	}
+	if preset.MaxBytes != 0 && !flags.Changed("max-bytes") {
+		imageMaxBytes = preset.MaxBytes
+	}
+	if preset.MaxDimension != 0 && !flags.Changed("max-dimension") {
+		imageMaxDimension = preset.MaxDimension
+	}
+}
+
+func supportedGravities() string {
+	names := make([]string, 0, len(gravities))
+	for k := range gravities {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// parseAspectRatio parses the ratio string like "16:9", "16/9" or "1.91:1" into width divided by height.
+func parseAspectRatio(aspect string) (float64, error) {
+	parts := strings.FieldsFunc(aspect, func(r rune) bool { return r == ':' || r == '/' })
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("the aspect ratio should be in W:H format")
+	}
+	w, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ratio width %q", parts[0])
+	}
+	h, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ratio height %q", parts[1])
+	}
+	// The NaN fails every comparison, it's rejected by the negated checks.
+	if !(w > 0 && h > 0) || math.IsInf(w, 0) || math.IsInf(h, 0) {
+		return 0, fmt.Errorf("the aspect ratio should be positive")
+	}
+	ratio := w / h
+	if !(ratio >= 1.0/MaxAspectRatio && ratio <= MaxAspectRatio) {
+		return 0, fmt.Errorf("the aspect ratio should be between 1:%d and %d:1", MaxAspectRatio, MaxAspectRatio)
+	}
+	return ratio, nil
+}
+
+// parseFocalPoint parses the focal point like "0.3,0.6", both fractions should be in [0, 1].
+func parseFocalPoint(focal string) (float64, float64, error) {
+	parts := strings.Split(focal, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("the focal point should be in x,y format")
+	}
+	x, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || x < 0 || x > 1 {
+		return 0, 0, fmt.Errorf("the focal x %q should be a fraction between 0 and 1", parts[0])
+	}
+	y, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || y < 0 || y > 1 {
+		return 0, 0, fmt.Errorf("the focal y %q should be a fraction between 0 and 1", parts[1])
+	}
+	return x, y, nil
+}
+
+// focalCrop computes the largest area in the target aspect ratio centered at the focal point,
+// the area is shifted inside the image if the focal point is close to the edges.
+func focalCrop(size bimg.ImageSize, width, height int, x, y float64) (left, top, w, h int) {
+	w, h = size.Width, size.Height
+	if float64(size.Width)*float64(height) > float64(size.Height)*float64(width) {
+		w = max(1, int(math.Round(float64(size.Height)*float64(width)/float64(height))))
+	} else {
+		h = max(1, int(math.Round(float64(size.Width)*float64(height)/float64(width))))
+	}
+	left = min(max(int(math.Round(x*float64(size.Width)))-w/2, 0), size.Width-w)
+	top = min(max(int(math.Round(y*float64(size.Height)))-h/2, 0), size.Height-h)
+	return left, top, w, h
+}
+
 func supportedFormats() string {
 	extensions := make([]string, 0, 10)
 	for k := range supportExtensions {
@@ -131,10 +598,129 @@ func supportedFormats() string {
 	return strings.Join(extensions, ", ")
 }
 
-func process(file *os.File, width, height int, dt time.Time, config *PandoraConfig) {
+func supportedRawFormats() string {
+	extensions := make([]string, 0, len(rawExtensions))
+	for k := range rawExtensions {
+		extensions = append(extensions, k)
+	}
+	sort.Strings(extensions)
+
+	return strings.Join(extensions, ", ")
+}
+
+// convertSource converts the source into the image of every --sizes width, the height is computed from the
+// aspect ratio if it's given. It returns the saved path of the last width.
+func convertSource(source string, ratio float64, config *PandoraConfig) (string, error) {
+	if err := operationContext.Err(); err != nil {
+		return "", exitErrorf(ExitFailure, "Skip the image %s, the operation is cancelled: %v", source, err)
+	}
+	widths := []int{width}
+	if len(imageSizes) > 0 {
+		widths = imageSizes
+	}
+	sizesBase = ""
+	links := len(imageLinks)
+	saved := ""
+	for _, w := range widths {
+		img, err := os.Open(source)
+		if err != nil {
+			err = exitErrorf(ExitFailure, "Failed to read image %v", err)
+			linkReport.Add(ReportEntry{Source: source, Error: err.Error()})
+			return "", err
+		}
+		h := height
+		if len(imageSizes) > 0 && ratio != 0 {
+			h = int(math.Round(float64(w) / ratio))
+		}
+		target, err := process(img, w, h, config)
+		_ = img.Close()
+		if err != nil {
+			return "", err
+		}
+		if target != "" {
+			saved = target
+		}
+	}
+	// The links of the responsive images are joined into a srcset.
+	if len(imageSizes) > 0 && len(imageLinks) > links {
+		srcset := strings.Join(imageLinks[links:], ", ")
+		imageLinks = append(imageLinks[:links], srcset)
+	}
+	return saved, nil
+}
+
+// imageBatch counts the converted images of the directory source and records the failures with the reasons.
+type imageBatch struct {
+	converted int
+	failures  []imageFailure
+}
+
+type imageFailure struct {
+	source string
+	err    error
+}
+
+func (b *imageBatch) Fail(source string, err error) {
+	b.failures = append(b.failures, imageFailure{source: source, err: err})
+}
+
+// Print logs the summary of the batch and the reason of every failed image.
+func (b *imageBatch) Print() {
+	if len(b.failures) == 0 {
+		successf("Successfully convert %d images", b.converted)
+		return
+	}
+	errorf("Converted %d images, %d failed:", b.converted, len(b.failures))
+	for _, failure := range b.failures {
+		errorf("  %s: %v", failure.source, failure.err)
+	}
+}
+
+// Err returns the ExitError if any image failed, it's a partial failure unless nothing is converted.
+func (b *imageBatch) Err() error {
+	if len(b.failures) == 0 {
+		return nil
+	}
+	code := ExitPartial
+	if b.converted == 0 {
+		code = ExitFailure
+	}
+	return &ExitError{Code: code, Err: fmt.Errorf("failed to convert %d images", len(b.failures))}
+}
+
+// process converts, saves and uploads the image. It returns the saved image path,
+// or an empty string if nothing is saved.
+func process(file *os.File, width, height int, config *PandoraConfig) (_ string, err error) {
+	source := file.Name()
+	// The saved image is reported with its link, the failed one with the reason.
+	entry := ReportEntry{Source: source}
+	defer func() {
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if err != nil || entry.Output != "" {
+			linkReport.Add(entry)
+		}
+	}()
+
 	bytes, err := io.ReadAll(file)
 	if err != nil {
-		log.Fatalf("Failed to read the image %s\nError: %v", file.Name(), err)
+		return "", exitErrorf(ExitFailure, "Failed to read the image %s\nError: %v", file.Name(), err)
+	}
+	dt := resolveImageDate(imageLocalDate, file.Name(), bytes, config)
+
+	// The SVG source is rasterized at the given density or the target width.
+	isSVG := bimg.IsSVGImage(bytes)
+	if isSVG && imageFormat != SVG {
+		bytes = scaleSVG(bytes, imageDensity, width)
+	}
+	if !isSVG && imageFormat == SVG {
+		return "", exitErrorf(ExitFailure, "The raster image couldn't be converted into SVG format")
+	}
+
+	// The image is upright before computing the size, the rotated image swaps its width and height.
+	if bytes, err = autoOrient(bytes); err != nil {
+		return "", exitErrorf(ExitFailure, "Failed to rotate the image %s by the EXIF orientation\nError: %v", file.Name(), err)
 	}
 
 	// Image conversion.
@@ -146,58 +732,597 @@ func process(file *os.File, width, height int, dt time.Time, config *PandoraConf
 		Crop:    false,
 		Quality: imageQuality,
 		Rotate:  0,
+		Gravity: gravities[imageGravity],
 		Type:    it,
+		// The --copyright and --author are written after stripping when the image is saved by libvips.
+		StripMetadata: imageStripMetadata,
+		Interlace:     imageProgressive && isInterlaced(imageFormat),
 	}
 	size, err := image.Size()
 	if err != nil {
-		log.Fatalf("Image is invalid %v", err)
+		return "", exitErrorf(ExitFailure, "Image is invalid %v", err)
+	}
+	// The responsive image is never upscaled from the source.
+	if len(imageSizes) > 0 && width > size.Width {
+		debugf("Skip the %dw image, it exceeds the source width %d\n", width, size.Width)
+		return "", nil
 	}
-	if height == 0 {
-		options.Height = width * size.Height / size.Width
-		options.Crop = false
+	options.Width, options.Height, options.Crop = targetSize(width, height, size)
+	// The source is cropped around the focal point into the target aspect ratio, the resize keeps it in frame.
+	if imageFocal != "" && options.Crop {
+		left, top, w, h := focalCrop(size, options.Width, options.Height, focalX, focalY)
+		if _, err := image.Extract(top, left, w, h); err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to crop the image around the focal point: %v", err)
+		}
+		size = bimg.ImageSize{Width: w, Height: h}
+	}
+	// The upright and cropped source, the outputs are processed from it instead of the replaced buffer of the image.
+	pixels := image.Image()
+	if imageOG {
+		// The small source is enlarged for the exact size required by the social platforms.
+		options.Enlarge = true
+		if imageCaption != "" {
+			options.Watermark = captionWatermark(imageCaption, options.Width)
+		}
 	} else {
-		options.Crop = true
+		options.Width, options.Height = clampDimension(options.Width, options.Height, imageMaxDimension)
 	}
-	bytes, err = image.Process(options)
-	if err != nil {
-		log.Fatalf("Failed to convert the images: %v", err)
+	// The already optimized source is kept as it is for avoiding the generational quality loss.
+	optimized := false
+	if !imageForce && imageFormat != SVG {
+		var reason string
+		if optimized, reason = isOptimized(image, size, options, len(bytes)); optimized {
+			debugf("Skip converting the image [%v], it's already optimized: %s\n", file.Name(), reason)
+			options.Width, options.Height = size.Width, size.Height
+		}
+	}
+	if !optimized && imageFormat != SVG {
+		// bimg doesn't enlarge the small source in cropping, the watermark is placed in the source size.
+		w, h := options.Width, options.Height
+		if options.Crop && !options.Enlarge && size.Width < w && size.Height < h {
+			w, h = size.Width, size.Height
+		}
+		if options.WatermarkImage, err = watermarkOptions(w, h); err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to render the watermark: %v", err)
+		}
+	}
+	if imageNormalize {
+		options.Brightness, options.Contrast, err = normalizeLevels(image)
+		if err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to normalize the image contrast: %v", err)
+		}
 	}
 
-	// Create directory.
+	// Resolve the target file.
 	directory := filepath.Join(config.ProjectRoot, "images", dt.Format("2006"), dt.Format("01"))
+	filename := timestampName(dt, directory)
+	if config.Convert.SequenceNaming && !outputAdjacent && imageOutputName == "" && !imageStdout && sizesBase == "" {
+		if sequence, e := nextSequence(dt); e != nil {
+			warnf("Failed to increment the naming sequence, fall back to the timestamp name.\nError: %v", e)
+		} else if name := fmt.Sprintf("%s-%03d.%s", dt.Format("20060102"), sequence, imageFormat); fileExists(filepath.Join(directory, name)) {
+			infof("The sequence name %s is taken, fall back to the timestamp name", name)
+		} else {
+			filename = name
+		}
+	}
+	if outputAdjacent {
+		directory = filepath.Dir(file.Name())
+		base := filepath.Base(file.Name())
+		filename = fmt.Sprintf("%s-%d.%s", strings.TrimSuffix(base, filepath.Ext(base)), options.Width, imageFormat)
+	}
+	if imageOutputName != "" {
+		filename = imageOutputName + "." + imageFormat
+		if _, err := os.Stat(filepath.Join(directory, filename)); err == nil && !imageStdout && !imageForce && !imageIfNewer {
+			return "", exitErrorf(ExitFailure, "The target image %s already exists, use --force for overwriting it", filepath.Join(directory, filename))
+		}
+	}
+	// The responsive images share the base name of the first width, like 20240101-001-768.jpg.
+	if len(imageSizes) > 0 && !outputAdjacent {
+		if sizesBase == "" {
+			sizesBase = strings.TrimSuffix(filename, filepath.Ext(filename))
+		}
+		filename = fmt.Sprintf("%s-%d.%s", sizesBase, width, imageFormat)
+	}
+	if !imageStdout && imageIfNewer && !imageForce && !isSourceNewer(file.Name(), filepath.Join(directory, filename)) {
+		debugf("Skip the image, the existing [%v] is newer than the source\n", filepath.Join(directory, filename))
+		return "", nil
+	}
+
+	// The extra formats are encoded from the resized source in the background.
+	waitFormats := encodeFormats(pixels, options, imageExtraFormats, imageQuality, imageConcurrency)
+
+	// The SVG target and the optimized source keep the source bytes as it is.
+	if !optimized && imageFormat != SVG && imageTargetSSIM > 0 {
+		lossless, e := ssimReference(image, options)
+		if e != nil {
+			return "", exitErrorf(ExitFailure, "Failed to convert the images: %v", e)
+		}
+		bytes, err = encodeWithinSSIM(newEncoder(image, options), lossless, imageQuality, imageMinQuality, imageTargetSSIM)
+		if err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to convert the images: %v", err)
+		}
+	} else if !optimized && imageFormat != SVG && imageMaxBytes > 0 {
+		bytes, err = encodeWithinBytes(newEncoder(image, options), imageQuality, imageMaxBytes, imageMinQuality)
+		if err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to convert the images: %v", err)
+		}
+	} else if !optimized && imageFormat != SVG {
+		bytes, err = newEncoder(image, options)(imageQuality)
+		if err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to convert the images: %v", err)
+		}
+	}
+
+	// The 2x variant for the high DPI screens is never upscaled from the source.
+	var retina []byte
+	if imageRetina {
+		retinaOptions := options
+		retinaOptions.Width, retinaOptions.Height = options.Width*2, options.Height*2
+		if retinaOptions.Width > size.Width || retinaOptions.Height > size.Height {
+			debugf("Skip the @2x image, the %dx%d exceeds the source %dx%d\n", retinaOptions.Width, retinaOptions.Height, size.Width, size.Height)
+		} else if retinaOptions.WatermarkImage, err = watermarkOptions(retinaOptions.Width, retinaOptions.Height); err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to render the @2x watermark: %v", err)
+		} else if retina, err = newEncoder(bimg.NewImage(pixels), retinaOptions)(imageQuality); err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to convert the @2x image: %v", err)
+		}
+	}
+
+	// Emit the image bytes for piping, the logs are written into the stderr.
+	if imageStdout {
+		_, err = os.Stdout.Write(bytes)
+		if err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to write the image to stdout: %v", err)
+		}
+		return "", nil
+	}
+
+	// Create directory.
 	err = os.MkdirAll(directory, os.FileMode(0755))
 	if err != nil {
-		log.Fatalf("Failed to create the image directory: %v", err)
+		return "", exitErrorf(ExitFailure, "Failed to create the image directory: %v", err)
 	}
 
 	// Save image file.
-	filename := dt.Format("20060102") + time.Now().Format("150405") + fmt.Sprintf("%02d", time.Now().Nanosecond()%100) + "." + imageFormat
 	file, err = os.OpenFile(filepath.Join(directory, filename), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(0644))
 	if err != nil {
-		log.Fatalf("Failed to generate the target image file: %v", filename)
+		return "", exitErrorf(ExitFailure, "Failed to generate the target image file: %v", filename)
 	}
 	writer := bufio.NewWriter(file)
 	_, err = writer.Write(bytes)
+	if err == nil {
+		err = writer.Flush()
+	}
 	if err != nil {
-		log.Fatalf("Failed to save image: %v", err)
+		return "", exitErrorf(ExitFailure, "Failed to save image: %v", err)
+	}
+
+	infof("The image is saved into the [%v]\n", filepath.Join(directory, filename))
+	entry.Output = filepath.Join(directory, filename)
+	if saved, e := bimg.NewImage(bytes).Size(); e == nil {
+		entry.Width, entry.Height = saved.Width, saved.Height
+	}
+
+	retinaFilename := retinaName(filename)
+	if retina != nil {
+		err = os.WriteFile(filepath.Join(directory, retinaFilename), retina, os.FileMode(0644))
+		if err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to save the @2x image: %v", err)
+		}
+		infof("The @2x image is saved into the [%v]\n", filepath.Join(directory, retinaFilename))
+	}
+
+	// The extra formats share the name of the image, like 20240101-001.avif.
+	extras := waitFormats()
+	for _, extra := range extras {
+		if extra.Err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to convert the %s image: %v", extra.Format, extra.Err)
+		}
+		extraPath := filepath.Join(directory, formatName(filename, extra.Format))
+		if err = os.WriteFile(extraPath, extra.Bytes, os.FileMode(0644)); err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to save the %s image: %v", extra.Format, err)
+		}
+		infof("The %s image is saved into the [%v]\n", extra.Format, extraPath)
+	}
+
+	if imageManifest != nil {
+		imageManifest.Source = source
+		if meta := ReadImageMetadata(filename, "", bytes); meta != nil {
+			imageManifest.Placeholder = meta.Placeholder
+		}
+		imageManifest.Add(ImageVariant{Format: imageFormat, Width: entry.Width, Height: entry.Height, Density: "1x", Path: filepath.Join(directory, filename)})
+		if retina != nil {
+			variant := ImageVariant{Format: imageFormat, Density: "2x", Path: filepath.Join(directory, retinaFilename)}
+			if saved, e := bimg.NewImage(retina).Size(); e == nil {
+				variant.Width, variant.Height = saved.Width, saved.Height
+			}
+			imageManifest.Add(variant)
+		}
+		for _, extra := range extras {
+			variant := ImageVariant{Format: extra.Format, Width: entry.Width, Height: entry.Height, Density: "1x",
+				Path: filepath.Join(directory, formatName(filename, extra.Format))}
+			imageManifest.Add(variant)
+		}
 	}
 
-	log.Printf("The image is saved into the [%v]\n", filepath.Join(directory, filename))
+	// The adjacent output could be placed outside the project, it couldn't be uploaded.
+	key, ok := projectKey(config, filepath.Join(directory, filename))
+	if !ok {
+		warnf("The image is outside the project root [%v], skip uploading\n", config.ProjectRoot)
+		return filepath.Join(directory, filename), nil
+	}
 
 	if uploadImage {
 		// Upload S3
-		client := newBucketClient(config)
-		err = client.UploadObject(context.TODO(), strings.ReplaceAll(filepath.Join(directory, filename)[len(config.ProjectRoot)+1:], string(filepath.Separator), "/"), bytes)
+		client, err := newBucketClient(config)
 		if err != nil {
-			log.Fatalf("Failed to upload the generated images to s3.\nError: %v", err)
+			return "", err
+		}
+		client.SetExpires(imageExpires)
+		localKey := key
+		key = client.RemoteKey(key)
+		err = client.UploadObject(operationContext, key, bytes, nil)
+		if isAuthError(err) {
+			return "", exitErrorf(ExitAuth, "The S3 credentials are rejected.\nError: %v", err)
+		} else if err != nil {
+			return "", exitErrorf(ExitFailure, "Failed to upload the generated images to s3.\nError: %v", err)
 		}
 
-		link, _ := url.JoinPath("https://cdn.yufan.me/images", dt.Format("2006"), dt.Format("01"), filename)
+		link, _ := url.JoinPath(config.BaseURL, key)
 		log.Printf("You can use link for document [%v]\n", link)
-		// Save into clipboard
-		clipboard.Write(clipboard.FmtText, []byte(link))
+		entry.URL = link
+		imageManifest.SetURL(filepath.Join(directory, filename), link)
+
+		if retina != nil {
+			retinaKey := client.RemoteKey(retinaName(localKey))
+			err = client.UploadObject(operationContext, retinaKey, retina, nil)
+			if err != nil {
+				return "", exitErrorf(ExitFailure, "Failed to upload the generated @2x images to s3.\nError: %v", err)
+			}
+			retinaLink, _ := url.JoinPath(config.BaseURL, retinaKey)
+			infof("You can use link for the @2x image [%v]\n", retinaLink)
+			imageManifest.SetURL(filepath.Join(directory, retinaFilename), retinaLink)
+		}
+
+		for _, extra := range extras {
+			extraKey := client.RemoteKey(formatName(localKey, extra.Format))
+			if err = client.UploadObject(operationContext, extraKey, extra.Bytes, nil); err != nil {
+				return "", exitErrorf(ExitFailure, "Failed to upload the generated %s images to s3.\nError: %v", extra.Format, err)
+			}
+			extraLink, _ := url.JoinPath(config.BaseURL, extraKey)
+			infof("You can use link for the %s image [%v]\n", extra.Format, extraLink)
+			imageManifest.SetURL(filepath.Join(directory, formatName(filename, extra.Format)), extraLink)
+		}
+		if len(imageSizes) > 0 {
+			link = fmt.Sprintf("%s %dw", link, entry.Width)
+		}
+		imageLinks = append(imageLinks, link)
+	}
+
+	return filepath.Join(directory, filename), nil
+}
+
+// ogSize returns the open graph image size in config or the default 1200x630.
+func ogSize(config *PandoraConfig) (int, int) {
+	w, h := config.Convert.OG.Width, config.Convert.OG.Height
+	if w == 0 {
+		w = DefaultOGWidth
+	}
+	if h == 0 {
+		h = DefaultOGHeight
+	}
+	return w, h
+}
+
+// captionWatermark draws the caption once at the bottom left of the image.
+func captionWatermark(caption string, width int) bimg.Watermark {
+	return bimg.Watermark{
+		Text:        caption,
+		Width:       width * 3 / 4,
+		DPI:         150,
+		Margin:      width / 24,
+		Opacity:     0.9,
+		NoReplicate: true,
+		Font:        "sans bold 16",
+		Background:  bimg.Color{R: 255, G: 255, B: 255},
+	}
+}
+
+// timestampName names the image by the date and the current time, like 2024010115040599.jpg.
+// The name is regenerated if it's taken, the images converted in the same second may collide.
+func timestampName(dt time.Time, directory string) string {
+	for {
+		now := time.Now()
+		filename := dt.Format("20060102") + now.Format("150405") + fmt.Sprintf("%02d", now.Nanosecond()%100) + "." + imageFormat
+		if !fileExists(filepath.Join(directory, filename)) {
+			return filename
+		}
+	}
+}
+
+// listImages returns the supported images in the directory in the name order, the subdirectories are
+// walked if it's recursive. The hidden files and directories are skipped.
+func listImages(dir string, recursive bool) ([]string, error) {
+	var images []string
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || name == dir {
+			return err
+		}
+		hidden := strings.HasPrefix(entry.Name(), ".")
+		if entry.IsDir() && (hidden || !recursive) {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || hidden {
+			return nil
+		}
+		if ok, _ := isSupportedImage(entry.Name()); ok || isRawImage(entry.Name()) && bimg.IsTypeSupported(bimg.MAGICK) {
+			images = append(images, name)
+		}
+		return nil
+	})
+	return images, err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// autoOrient applies the EXIF orientation to the pixels. The camera saves the portrait photo sideways
+// with an orientation tag, the resized size is computed from the upright image.
+func autoOrient(content []byte) ([]byte, error) {
+	metadata, err := bimg.NewImage(content).Metadata()
+	if err != nil || metadata.Orientation <= 1 || metadata.Orientation > 8 {
+		return content, nil
+	}
+	return vipsAutoOrient(content)
+}
+
+// normalizeSizes sorts the responsive widths and removes the duplicates.
+func normalizeSizes(sizes []int) ([]int, error) {
+	widths := slices.Clone(sizes)
+	slices.Sort(widths)
+	widths = slices.Compact(widths)
+	if widths[0] <= 0 {
+		return nil, fmt.Errorf("the width %d should be a positive number", widths[0])
+	}
+	return widths, nil
+}
+
+// retinaName inserts the @2x before the file extension, like image@2x.jpg.
+func retinaName(name string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "@2x" + ext
+}
+
+// newEncoder creates the function which encodes the image with the given options in a quality.
+// The image is resized into a lossless intermediate if the EXIF ownership or the chroma subsampling
+// should be set, libvips applies them when it's encoded into the target format.
+// Every encoding starts from the source bytes of the image, the bimg processing replaces the buffer
+// of the image and the probes in the different qualities would re-encode the previous output.
+func newEncoder(image *bimg.Image, options bimg.Options) func(quality int) ([]byte, error) {
+	source := image.Image()
+	save := vipsSaveOptions{
+		Copyright:   imageCopyright,
+		Author:      imageAuthor,
+		NoSubsample: imageNoSubsample && imageType(imageFormat) == bimg.JPEG,
+		OptimizePNG: imageOptimizePNG && imageType(imageFormat) == bimg.PNG,
+		PNGColors:   imagePNGColors,
+	}
+	if save == (vipsSaveOptions{}) {
+		return func(quality int) ([]byte, error) {
+			o := options
+			o.Quality = quality
+			return bimg.NewImage(source).Process(o)
+		}
+	}
+	save.Interlace = options.Interlace
+
+	intermediate := options
+	intermediate.Type = bimg.PNG
+	intermediate.Interlace = false
+	resized, err := bimg.NewImage(source).Process(intermediate)
+	return func(quality int) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		out, e := vipsSave(resized, imageFormat, quality, save)
+		if e == nil && save.OptimizePNG {
+			infof("Optimize the png from %d bytes into %d bytes, %.1f%% smaller\n",
+				len(resized), len(out), 100-float64(len(out))*100/float64(len(resized)))
+		}
+		return out, e
+	}
+}
+
+// targetSize computes the resized size of the source and whether it's cropped. The unset width is computed
+// from the height for keeping the ratio, it's the DefaultImageWidth if the height is unset too.
+func targetSize(width, height int, size bimg.ImageSize) (int, int, bool) {
+	if width == AutoWidth && height == 0 {
+		width = DefaultImageWidth
+	}
+	switch {
+	case width == AutoWidth:
+		return height * size.Width / size.Height, height, false
+	case height == 0:
+		return width, width * size.Height / size.Width, false
+	}
+	return width, height, true
+}
+
+// isOptimized checks the source looks like a converted output, re-converting it only loses the quality.
+// The source should be in the target format and fit the target size without cropping.
+func isOptimized(image *bimg.Image, size bimg.ImageSize, options bimg.Options, length int) (bool, string) {
+	if image.Type() != bimg.ImageTypeName(options.Type) {
+		return false, ""
+	}
+	if options.Crop || imageNormalize || imageCopyright != "" || imageAuthor != "" || imageNoSubsample || imageOptimizePNG || imageProgressive || hasWatermark() {
+		return false, ""
+	}
+	if size.Width > options.Width || size.Height > options.Height {
+		return false, ""
+	}
+	// The source with the camera metadata should be stripped for the privacy.
+	if options.StripMetadata {
+		if metadata, err := image.Metadata(); err != nil || metadata.EXIF != (bimg.EXIF{}) {
+			return false, ""
+		}
+	}
+	if imageMaxBytes > 0 && length > imageMaxBytes {
+		return false, ""
+	}
+	return true, fmt.Sprintf("the %s image is %dx%d within the target %dx%d", image.Type(), size.Width, size.Height, options.Width, options.Height)
+}
+
+// copyToClipboard saves the text into the system clipboard. The clipboard is unavailable on headless
+// systems, such as Linux without X11 or Wayland, a warning is logged instead of failing the command.
+func copyToClipboard(text string) {
+	defer func() {
+		if r := recover(); r != nil {
+			warnf("The clipboard is unavailable, skip copying the link: %v\n", r)
+		}
+	}()
+
+	if err := clipboard.Init(); err != nil {
+		warnf("The clipboard is unavailable, skip copying the link: %v\n", err)
+		return
+	}
+	clipboard.Write(clipboard.FmtText, []byte(text))
+}
+
+// normalizeLevels samples the luminance histogram of a small preview and computes the linear transform
+// which stretches the 0.5% - 99.5% percentile range to the full 0 - 255 range.
+// The returned brightness is applied before the contrast by bimg. The preview is processed from a copy,
+// the bimg processing replaces the buffer of the given image.
+func normalizeLevels(img *bimg.Image) (float64, float64, error) {
+	preview, err := bimg.NewImage(img.Image()).Process(bimg.Options{Width: 256, Type: bimg.PNG})
+	if err != nil {
+		return 0, 0, err
+	}
+	decoded, err := png.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var histogram [256]int
+	bounds := decoded.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			histogram[color.GrayModel.Convert(decoded.At(x, y)).(color.Gray).Y]++
+		}
+	}
+
+	total := bounds.Dx() * bounds.Dy()
+	clip := total / 200
+	low, high := 0, 255
+	for count := 0; low < 255 && count+histogram[low] <= clip; low++ {
+		count += histogram[low]
+	}
+	for count := 0; high > 0 && count+histogram[high] <= clip; high-- {
+		count += histogram[high]
+	}
+	if high <= low || (low == 0 && high == 255) {
+		// The image is a solid color or has the full dynamic range already.
+		return 0, 0, nil
 	}
 
+	debugf("Normalize the image levels from [%d, %d] to [0, 255]\n", low, high)
+	return -float64(low), 255 / float64(high-low), nil
+}
+
+// encodeWithinBytes binary searches the highest quality between the min quality and the requested quality,
+// whose output fits in the max bytes. The min quality output is kept if the size target couldn't be achieved.
+func encodeWithinBytes(encode func(quality int) ([]byte, error), quality, maxBytes, minQuality int) ([]byte, error) {
+	var best []byte
+	bestQuality := 0
+	low, high := minQuality, quality
+	for low <= high {
+		quality = (low + high) / 2
+		encoded, err := encode(quality)
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) <= maxBytes {
+			best, bestQuality = encoded, quality
+			low = quality + 1
+		} else {
+			high = quality - 1
+		}
+	}
+
+	if best == nil {
+		encoded, err := encode(minQuality)
+		if err != nil {
+			return nil, err
+		}
+		warnf("The size target %d bytes is not achievable, keep the min quality %d with %d bytes\n", maxBytes, minQuality, len(encoded))
+		return encoded, nil
+	}
+	debugf("Encode the image with quality %d in %d bytes\n", bestQuality, len(best))
+	return best, nil
+}
+
+// deleteSource removes the source image after it's converted successfully.
+// The source is kept if it's overwritten by the converted image.
+func deleteSource(source, target string) error {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		errorf("Failed to read the source image %s, skip deleting it", source)
+		return nil
+	}
+	if targetInfo, err := os.Stat(target); err == nil && os.SameFile(sourceInfo, targetInfo) {
+		debugf("The source image %s is the converted image, skip deleting it", source)
+		return nil
+	}
+	err = os.Remove(source)
+	if err != nil {
+		return exitErrorf(ExitFailure, "Failed to delete the source image %s\nError: %v", source, err)
+	}
+	infof("The source image [%v] is deleted\n", source)
+	return nil
+}
+
+// isSourceNewer checks the source file is modified after the target file. It's true if the target doesn't exist.
+func isSourceNewer(source, target string) bool {
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return true
+	}
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return true
+	}
+	return sourceInfo.ModTime().After(targetInfo.ModTime())
+}
+
+// clampDimension scales down the width and height proportionally if the longest side exceeds the max dimension.
+func clampDimension(width, height, maxDimension int) (int, int) {
+	longest := max(width, height)
+	if maxDimension <= 0 || longest <= maxDimension {
+		return width, height
+	}
+
+	scale := float64(maxDimension) / float64(longest)
+	w, h := int(math.Round(float64(width)*scale)), int(math.Round(float64(height)*scale))
+	debugf("Clamp the image size from %dx%d to %dx%d by the max dimension %d\n", width, height, w, h, maxDimension)
+	return w, h
+}
+
+// projectKey converts the local file path into the S3 object key, which is relative to the project root.
+// It returns false if the file isn't placed in the project root.
+func projectKey(config *PandoraConfig, path string) (string, bool) {
+	root, err := filepath.Abs(config.ProjectRoot)
+	if err != nil {
+		return "", false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
 }
 
 func isSupportedImage(name string) (bool, string) {
@@ -206,25 +1331,35 @@ func isSupportedImage(name string) (bool, string) {
 	return ok, ext
 }
 
+func isRawImage(name string) bool {
+	ext := strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+	_, ok := rawExtensions[ext]
+	return ok
+}
+
+// isInterlaced reports whether the format could be saved in the progressive jpg or the interlaced png.
+func isInterlaced(format string) bool {
+	t := imageType(format)
+	return t == bimg.JPEG || t == bimg.PNG
+}
+
+// imageType maps the file extension in supportExtensions to the bimg saving type, it's case-insensitive.
+// The formats which libvips couldn't save return bimg.UNKNOWN, the image command falls back to jpg for them.
 func imageType(format string) bimg.ImageType {
-	switch format {
-	case JPG:
-	case JPEG:
+	switch strings.ToLower(format) {
+	case JPG, JPEG:
 		return bimg.JPEG
-	case PNG:
+	case PNG, APNG:
 		return bimg.PNG
 	case AVIF:
 		return bimg.AVIF
 	case GIF:
 		return bimg.GIF
-	case APNG:
-		return bimg.PNG
-	case BMP:
-		return bimg.JPEG
 	case WEBP:
 		return bimg.WEBP
 	case SVG:
 		return bimg.SVG
 	}
-	return bimg.JPEG
+	// The BMP could only be loaded, libvips has no BMP saver.
+	return bimg.UNKNOWN
 }
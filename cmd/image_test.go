@@ -0,0 +1,622 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+// requireVips skips the test which processes the image if the linked libvips couldn't decode jpg.
+func requireVips(t *testing.T) {
+	t.Helper()
+	if !bimg.IsTypeSupported(bimg.JPEG) {
+		t.Skip("libvips with the jpg support is unavailable")
+	}
+}
+
+// testImage draws the synthetic image in the given size, the pixel color is computed by the fill function.
+func testImage(width, height int, fill func(x, y int) color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, fill(x, y))
+		}
+	}
+	return img
+}
+
+// testJPEG encodes the synthetic image into jpg.
+func testJPEG(t *testing.T, width, height int, fill func(x, y int) color.Color) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(width, height, fill), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIF inserts the EXIF APP1 segment with the IFD0 camera make after the jpg SOI marker.
+func withEXIF(t *testing.T, content []byte, camera string) []byte {
+	t.Helper()
+	value := append([]byte(camera), 0)
+	// The little-endian TIFF header, one IFD0 entry and the string value after the IFD.
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0}
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x010F)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(len(value)))
+	tiff = binary.LittleEndian.AppendUint32(tiff, 26)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, value...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(segment)+2))
+	if len(content) < 2 || content[0] != 0xFF || content[1] != 0xD8 {
+		t.Fatal("The content isn't a jpg image")
+	}
+	result := append([]byte{0xFF, 0xD8}, app1...)
+	result = append(result, segment...)
+	return append(result, content[2:]...)
+}
+
+// gradient fills the image with the horizontal gray gradient.
+func gradient(x, _ int) color.Color {
+	return color.Gray{Y: uint8(x % 256)}
+}
+
+func TestImageType(t *testing.T) {
+	expected := map[string]bimg.ImageType{
+		JPEG: bimg.JPEG,
+		JPG:  bimg.JPEG,
+		PNG:  bimg.PNG,
+		APNG: bimg.PNG,
+		AVIF: bimg.AVIF,
+		WEBP: bimg.WEBP,
+		GIF:  bimg.GIF,
+		SVG:  bimg.SVG,
+		// libvips has no BMP saver, the image command falls back to jpg.
+		BMP: bimg.UNKNOWN,
+	}
+	for ext := range supportExtensions {
+		want, ok := expected[ext]
+		if !ok {
+			t.Errorf("The extension %s has no expected image type in the test", ext)
+			continue
+		}
+		if got := imageType(ext); got != want {
+			t.Errorf("imageType(%q) = %v, want %v", ext, got, want)
+		}
+	}
+
+	tests := []struct {
+		format string
+		want   bimg.ImageType
+	}{
+		{"JPG", bimg.JPEG},
+		{"Png", bimg.PNG},
+		{"WebP", bimg.WEBP},
+		{"tiff", bimg.UNKNOWN},
+		{"", bimg.UNKNOWN},
+	}
+	for _, tt := range tests {
+		if got := imageType(tt.format); got != tt.want {
+			t.Errorf("imageType(%q) = %v, want %v", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultFormat(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "convert:\n  defaultFormat: webp\npresets:\n  blog:\n    format: avif\n")
+	source := filepath.Join(dir, "missing.jpg")
+	original := configPath
+	defer func() {
+		configPath, imageFormat, imagePreset, uploadImage = original, "", "", true
+		rootCmd.SetArgs(nil)
+	}()
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"config default", nil, WEBP},
+		{"preset", []string{"--preset", "blog"}, AVIF},
+		{"flag", []string{"--preset", "blog", "--format", "png"}, PNG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageFormat, imagePreset, uploadImage = "", "", true
+			for _, name := range []string{"format", "preset", "upload"} {
+				imageCmd.Flags().Lookup(name).Changed = false
+			}
+			rootCmd.SetArgs(append([]string{"image", "--config", dir, "--source", source, "--upload=false"}, tt.args...))
+			// The missing source fails the command after the format is resolved.
+			if err := rootCmd.Execute(); err == nil {
+				t.Fatal("The missing source should fail the command")
+			}
+			if imageFormat != tt.want {
+				t.Errorf("The format is %q, want %q", imageFormat, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsInterlaced(t *testing.T) {
+	for format, want := range map[string]bool{JPG: true, JPEG: true, PNG: true, WEBP: false, AVIF: false, GIF: false, SVG: false} {
+		if got := isInterlaced(format); got != want {
+			t.Errorf("isInterlaced(%q) = %v, want %v", format, got, want)
+		}
+	}
+}
+
+func TestTargetSize(t *testing.T) {
+	source := bimg.ImageSize{Width: 1600, Height: 1200}
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+		wantCrop              bool
+	}{
+		{"none", AutoWidth, 0, DefaultImageWidth, 960, false},
+		{"width only", 800, 0, 800, 600, false},
+		{"height only", AutoWidth, 300, 400, 300, false},
+		{"both", 500, 500, 500, 500, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, crop := targetSize(tt.width, tt.height, source)
+			if w != tt.wantWidth || h != tt.wantHeight || crop != tt.wantCrop {
+				t.Errorf("targetSize(%d, %d) = %dx%d crop %v, want %dx%d crop %v",
+					tt.width, tt.height, w, h, crop, tt.wantWidth, tt.wantHeight, tt.wantCrop)
+			}
+		})
+	}
+}
+
+func TestParseAspectRatio(t *testing.T) {
+	tests := []struct {
+		aspect string
+		want   float64
+		ok     bool
+	}{
+		{"16:9", 16.0 / 9, true},
+		{"1.91/1", 1.91, true},
+		{"1:100", 0.01, true},
+		{"16", 0, false},
+		{"0:1", 0, false},
+		{"-4:3", 0, false},
+		{"NaN:1", 0, false},
+		{"1:NaN", 0, false},
+		{"Inf:1", 0, false},
+		{"1e-300:1", 0, false},
+		{"1:1e-300", 0, false},
+		{"101:1", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aspect, func(t *testing.T) {
+			got, err := parseAspectRatio(tt.aspect)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("parseAspectRatio(%q) = %v, %v, want %v", tt.aspect, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResizeDimensions(t *testing.T) {
+	requireVips(t)
+	source := testJPEG(t, 1600, 1200, gradient)
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"none", AutoWidth, 0, DefaultImageWidth, 960},
+		{"width only", 800, 0, 800, 600},
+		{"height only", AutoWidth, 300, 400, 300},
+		{"both", 500, 500, 500, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, crop := targetSize(tt.width, tt.height, bimg.ImageSize{Width: 1600, Height: 1200})
+			out, err := bimg.NewImage(source).Process(bimg.Options{Width: w, Height: h, Crop: crop, Type: bimg.JPEG})
+			if err != nil {
+				t.Fatal(err)
+			}
+			size, err := bimg.NewImage(out).Size()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size.Width != tt.wantWidth || size.Height != tt.wantHeight {
+				t.Errorf("The resized image is %dx%d, want %dx%d", size.Width, size.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestRetinaFromSource(t *testing.T) {
+	requireVips(t)
+	// The 4px stripes are blurred if the @2x image is upscaled from the 1x output.
+	content := testJPEG(t, 1280, 960, func(x, _ int) color.Color { return color.Gray{Y: uint8(x / 4 % 2 * 255)} })
+	root := t.TempDir()
+	source := filepath.Join(root, "source.jpg")
+	if err := os.WriteFile(source, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	format, quality, upload, retina, name := imageFormat, imageQuality, uploadImage, imageRetina, imageOutputName
+	defer func() {
+		imageFormat, imageQuality, uploadImage, imageRetina, imageOutputName = format, quality, upload, retina, name
+	}()
+	imageFormat, imageQuality, uploadImage, imageRetina, imageOutputName = JPG, 95, false, true, "retina"
+
+	file, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	target, err := process(file, 320, 0, &PandoraConfig{ProjectRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(retinaName(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := bimg.NewImage(content).Process(bimg.Options{Width: 640, Height: 480, Type: bimg.PNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ssimPreview(expected, 640, 480)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ssimPreview(saved, 640, 480)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := ssim(a, b); score < 0.95 {
+		t.Errorf("The @2x image should be resized from the source, the SSIM is %.4f", score)
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	requireVips(t)
+	source := withEXIF(t, testJPEG(t, 64, 48, gradient), "PandoraCamera")
+	if metadata, err := bimg.Metadata(source); err != nil || metadata.EXIF.Make != "PandoraCamera" {
+		t.Fatalf("The fixture should have the EXIF camera make, got %+v, %v", metadata.EXIF, err)
+	}
+
+	format, copyright, author := imageFormat, imageCopyright, imageAuthor
+	defer func() { imageFormat, imageCopyright, imageAuthor = format, copyright, author }()
+	imageFormat = JPG
+
+	tests := []struct {
+		name              string
+		copyright, author string
+	}{
+		{"stripped", "", ""},
+		{"copyright", "CC BY 4.0 Pandora", ""},
+		{"copyright and author", "CC BY 4.0 Pandora", "Syhily"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageCopyright, imageAuthor = tt.copyright, tt.author
+			encode := newEncoder(bimg.NewImage(source), bimg.Options{Type: bimg.JPEG, StripMetadata: true})
+			out, err := encode(80)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, []byte("PandoraCamera")) {
+				t.Error("The camera make should be stripped")
+			}
+			if metadata, err := bimg.Metadata(out); err != nil || metadata.EXIF.Make != "" {
+				t.Errorf("The EXIF should be stripped, got %+v, %v", metadata.EXIF, err)
+			}
+			if tt.copyright == "" && tt.author == "" && bytes.Contains(out, []byte("Exif\x00\x00")) {
+				t.Error("The output shouldn't have the EXIF segment without the copyright and author")
+			}
+			for _, value := range []string{tt.copyright, tt.author} {
+				if value != "" && !bytes.Contains(out, []byte(value)) {
+					t.Errorf("The output should have %q in the EXIF", value)
+				}
+			}
+			if tt.author == "" && bytes.Contains(out, []byte("Syhily")) {
+				t.Error("The output shouldn't have the unset author")
+			}
+		})
+	}
+}
+
+func TestEncoderProbes(t *testing.T) {
+	requireVips(t)
+	source := testJPEG(t, 640, 480, gradient)
+	format, noSubsample := imageFormat, imageNoSubsample
+	defer func() { imageFormat, imageNoSubsample = format, noSubsample }()
+	imageFormat = JPG
+
+	for _, intermediate := range []bool{false, true} {
+		imageNoSubsample = intermediate
+		image := bimg.NewImage(source)
+		encode := newEncoder(image, bimg.Options{Width: 320, Height: 240, Type: bimg.JPEG})
+		first, err := encode(80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := encodeWithinBytes(encode, 80, len(first)/2, 10); err != nil {
+			t.Fatal(err)
+		}
+		again, err := encode(80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, again) {
+			t.Errorf("The probe should encode the source instead of the previous output, the intermediate is %v", intermediate)
+		}
+		if !bytes.Equal(image.Image(), source) {
+			t.Errorf("The encoder shouldn't replace the buffer of the image, the intermediate is %v", intermediate)
+		}
+	}
+}
+
+func TestNoSubsample(t *testing.T) {
+	requireVips(t)
+	// The red and blue stripes are the colored edges blurred by the 4:2:0 chroma subsampling.
+	// The source is the lossless png, the jpg encoder of Go subsamples the chroma itself.
+	stripes := testImage(256, 256, func(x, _ int) color.Color {
+		if x/2%2 == 0 {
+			return color.RGBA{R: 255, A: 255}
+		}
+		return color.RGBA{B: 255, A: 255}
+	})
+	var source bytes.Buffer
+	if err := png.Encode(&source, stripes); err != nil {
+		t.Fatal(err)
+	}
+
+	format, noSubsample := imageFormat, imageNoSubsample
+	defer func() { imageFormat, imageNoSubsample = format, noSubsample }()
+	imageFormat = JPG
+
+	encode := func(subsample bool) []byte {
+		imageNoSubsample = !subsample
+		out, err := newEncoder(bimg.NewImage(source.Bytes()), bimg.Options{Type: bimg.JPEG})(90)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	subsampled, full := encode(true), encode(false)
+	if len(full) <= len(subsampled) {
+		t.Errorf("The 4:4:4 output %d bytes should be larger than the 4:2:0 output %d bytes", len(full), len(subsampled))
+	}
+	if chromaError(t, stripes, full) >= chromaError(t, stripes, subsampled) {
+		t.Error("The 4:4:4 output should keep the colored edges sharper than the 4:2:0 output")
+	}
+}
+
+// chromaError sums the absolute red and blue difference of the decoded jpg output against the source.
+func chromaError(t *testing.T, source image.Image, output []byte) int {
+	t.Helper()
+	actual, err := jpeg.Decode(bytes.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	bounds := source.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, _, b1, _ := source.At(x, y).RGBA()
+			r2, _, b2, _ := actual.At(x, y).RGBA()
+			total += abs(int(r1>>8)-int(r2>>8)) + abs(int(b1>>8)-int(b2>>8))
+		}
+	}
+	return total
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func TestNormalizeLevels(t *testing.T) {
+	requireVips(t)
+	// The flat gradient only spans the gray levels between 100 and 150, it's wider than the 256px preview.
+	source := testJPEG(t, 1024, 64, func(x, _ int) color.Color {
+		return color.Gray{Y: uint8(100 + x*50/1023)}
+	})
+	img := bimg.NewImage(source)
+	brightness, contrast, err := normalizeLevels(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contrast <= 1 {
+		t.Fatalf("The low-contrast image should be stretched, got the contrast %v", contrast)
+	}
+
+	// The same image is processed after sampling the levels, like the image command does.
+	before := lumaRange(t, source)
+	out, err := img.Process(bimg.Options{Width: 800, Brightness: brightness, Contrast: contrast, Type: bimg.PNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := lumaRange(t, out)
+	if after < 200 || after <= before {
+		t.Errorf("The dynamic range should expand from %d to at least 200, got %d", before, after)
+	}
+	size, err := bimg.NewImage(out).Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Width != 800 || size.Height != 50 {
+		t.Errorf("The image should be resized from the 1024px source into 800x50, got %dx%d", size.Width, size.Height)
+	}
+}
+
+// lumaRange decodes the image and returns the difference between its brightest and darkest gray levels.
+func lumaRange(t *testing.T, content []byte) int {
+	t.Helper()
+	decoded, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, high := 255, 0
+	bounds := decoded.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := int(color.GrayModel.Convert(decoded.At(x, y)).(color.Gray).Y)
+			low, high = min(low, gray), max(high, gray)
+		}
+	}
+	return high - low
+}
+
+func TestContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "convert:\n  defaultQuality: 75\n")
+	source := filepath.Join(dir, "shoot")
+	if err := os.MkdirAll(source, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// The dangling links are listed as the images, they fail on reading.
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := os.Symlink(filepath.Join(dir, "missing", name), filepath.Join(source, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original := configPath
+	defer func() {
+		configPath, imageContinueOnError, uploadImage, imageReport, linkReport = original, true, true, "", nil
+		rootCmd.SetArgs(nil)
+	}()
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantErr    string
+		wantReport int
+	}{
+		{"continue", nil, ExitFailure, "failed to convert 2 images", 2},
+		{"fail fast", []string{"--continue-on-error=false"}, ExitFailure, "Failed to read image", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageContinueOnError, uploadImage = true, true
+			for _, name := range []string{"continue-on-error", "upload"} {
+				imageCmd.Flags().Lookup(name).Changed = false
+			}
+			report := filepath.Join(t.TempDir(), "report.json")
+			rootCmd.SetArgs(append([]string{"image", "--config", dir, "--source", source, "--upload=false", "--report", report}, tt.args...))
+			err := rootCmd.Execute()
+			if err == nil || exitCode(err) != tt.wantCode || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("The command fails with %v, want the code %d and %q", err, tt.wantCode, tt.wantErr)
+			}
+
+			// The failed images are written into the report with the reasons.
+			data, err := os.ReadFile(report)
+			if err != nil {
+				t.Fatalf("The report isn't written: %v", err)
+			}
+			var entries []ReportEntry
+			if err := json.Unmarshal(data, &entries); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.wantReport {
+				t.Fatalf("The report has %d entries, want %d", len(entries), tt.wantReport)
+			}
+			for _, entry := range entries {
+				if !strings.Contains(entry.Error, "Failed to read image") || entry.URL != "" {
+					t.Errorf("The report entry %+v should have the failure reason", entry)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteSourceInBatch(t *testing.T) {
+	requireVips(t)
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "convert:\n  defaultQuality: 75\n")
+	source := filepath.Join(dir, "shoot")
+	if err := os.MkdirAll(source, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	converted := filepath.Join(source, "a.jpg")
+	if err := os.WriteFile(converted, testJPEG(t, 640, 480, gradient), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The dangling link fails the batch after the first image is converted.
+	if err := os.Symlink(filepath.Join(dir, "missing.jpg"), filepath.Join(source, "b.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	original := configPath
+	defer func() {
+		configPath, imageContinueOnError, uploadImage, imageDeleteSource = original, true, true, false
+		rootCmd.SetArgs(nil)
+	}()
+	for _, name := range []string{"continue-on-error", "upload", "delete-source"} {
+		imageCmd.Flags().Lookup(name).Changed = false
+	}
+
+	rootCmd.SetArgs([]string{"image", "--config", dir, "--source", source, "--upload=false", "--delete-source", "--continue-on-error=false"})
+	if err := rootCmd.Execute(); err == nil {
+		t.Fatal("The dangling link should fail the batch")
+	}
+	if _, err := os.Stat(converted); !os.IsNotExist(err) {
+		t.Errorf("The converted source should be deleted before the batch fails, got %v", err)
+	}
+}
+
+func TestImageBatchErr(t *testing.T) {
+	batch := &imageBatch{}
+	if err := batch.Err(); err != nil {
+		t.Errorf("The batch without failures should succeed, got %v", err)
+	}
+	batch.Fail("a.jpg", errors.New("corrupt"))
+	if code := exitCode(batch.Err()); code != ExitFailure {
+		t.Errorf("Nothing is converted, the code is %d, want %d", code, ExitFailure)
+	}
+	batch.converted++
+	if code := exitCode(batch.Err()); code != ExitPartial {
+		t.Errorf("Some images are converted, the code is %d, want %d", code, ExitPartial)
+	}
+}
+
+func TestProcessReportsFailure(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "photo.png")
+	if err := os.WriteFile(source, []byte("not an svg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	defer func() { imageFormat, linkReport = "", nil }()
+
+	imageFormat, linkReport = SVG, &LinkReport{}
+	if _, err := process(file, 800, 0, &PandoraConfig{ProjectRoot: dir}); err == nil {
+		t.Fatal("The raster image shouldn't be converted into SVG")
+	}
+	if len(linkReport.entries) != 1 {
+		t.Fatalf("The report has %d entries, want the failed image", len(linkReport.entries))
+	}
+	if entry := linkReport.entries[0]; entry.Source != source || !strings.Contains(entry.Error, "SVG") {
+		t.Errorf("The report entry %+v should have the source and the failure reason", entry)
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/json"
+	"html/template"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	IndexJSON = "json"
+	IndexHTML = "html"
+)
+
+// DirectoryEntry is an immediate child of the directory in the index object.
+type DirectoryEntry struct {
+	Name         string     `json:"name"`
+	Directory    bool       `json:"directory,omitempty"`
+	Size         int64      `json:"size,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+}
+
+var directoryIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of /{{.Dir}}</title>
+</head>
+<body>
+<h1>Index of /{{.Dir}}</h1>
+<ul>
+{{- if .Dir}}
+<li><a href="../">../</a></li>
+{{- end}}
+{{- range .Entries}}
+{{- if .Directory}}
+<li><a href="{{.Name}}/">{{.Name}}/</a></li>
+{{- else}}
+<li><a href="{{.Name}}">{{.Name}}</a> {{.Size}} bytes</li>
+{{- end}}
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// isValidIndexFormat checks the sync.directoryIndex in config file, the empty format disables the index.
+func isValidIndexFormat(format string) bool {
+	return format == "" || format == IndexJSON || format == IndexHTML
+}
+
+// buildDirectoryIndex groups the object keys by their parent directories. Every directory, including
+// the bucket root "", lists its immediate files and subdirectories sorted by name.
+func buildDirectoryIndex(objects []DirectoryEntry, indexName string) map[string][]DirectoryEntry {
+	index := map[string][]DirectoryEntry{"": nil}
+	seen := map[string]bool{}
+	for _, object := range objects {
+		if path.Base(object.Name) == indexName {
+			continue
+		}
+		dir := path.Dir(object.Name)
+		if dir == "." {
+			dir = ""
+		}
+		entry := object
+		entry.Name = path.Base(object.Name)
+		index[dir] = append(index[dir], entry)
+		// Register the directory in all its ancestors.
+		for dir != "" && !seen[dir] {
+			seen[dir] = true
+			parent := path.Dir(dir)
+			if parent == "." {
+				parent = ""
+			}
+			index[parent] = append(index[parent], DirectoryEntry{Name: path.Base(dir), Directory: true})
+			dir = parent
+		}
+	}
+	for _, entries := range index {
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].Directory != entries[j].Directory {
+				return entries[i].Directory
+			}
+			return entries[i].Name < entries[j].Name
+		})
+	}
+	return index
+}
+
+// renderDirectoryIndex generates the index object of the directory in the given format.
+func renderDirectoryIndex(format, dir string, entries []DirectoryEntry) ([]byte, error) {
+	if entries == nil {
+		entries = []DirectoryEntry{}
+	}
+	if format == IndexJSON {
+		return json.MarshalIndent(entries, "", "  ")
+	}
+	if dir != "" {
+		dir += "/"
+	}
+	var out strings.Builder
+	err := directoryIndexTemplate.Execute(&out, struct {
+		Dir     string
+		Entries []DirectoryEntry
+	}{Dir: dir, Entries: entries})
+	return []byte(out.String()), err
+}
+
+// UploadDirectoryIndex uploads an index object into every directory of the bucket for browsing them
+// on the static hosts without the native listing, like images/2024/index.json.
+func UploadDirectoryIndex(client *BucketClient, config *PandoraConfig) {
+	format := config.Sync.DirectoryIndex
+	if format == "" {
+		return
+	}
+
+	objects, err := client.ListObjects(operationContext, "")
+	if err != nil {
+		errorf("Failed to list the objects for the directory index.\nError: %v", err)
+		return
+	}
+	entries := make([]DirectoryEntry, 0, len(objects))
+	for _, object := range objects {
+		entries = append(entries, DirectoryEntry{Name: aws.ToString(object.Key), Size: aws.ToInt64(object.Size), LastModified: object.LastModified})
+	}
+
+	indexName := "index." + format
+	index := buildDirectoryIndex(entries, indexName)
+	for _, dir := range sortedKeys(index) {
+		content, err := renderDirectoryIndex(format, dir, index[dir])
+		if err != nil {
+			errorf("Failed to generate the directory index of [%v].\nError: %v", dir, err)
+			continue
+		}
+		key := path.Join(dir, indexName)
+		if err := client.UploadObject(operationContext, key, content, nil); err != nil {
+			errorf("Failed to upload the directory index %s", key)
+		}
+	}
+	successf("Successfully upload the directory index of %d directories", len(index))
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	ProjectConfigDir = ".pandora"
+
+	// projectConfigTemplate is the default project-local config file.
+	// The secrets are left blank on purpose for checking the file into the content repository.
+	projectConfigTemplate = `# The project-local configuration for pandora tool.
+# It's safe to commit this file, the secrets should be provided by the environment variables.
+
+# The root directory for storing the images and uploads, relative to the parent of .pandora directory.
+projectRoot: .
+# The public URL which maps to the bucket root.
+baseURL: https://cdn.yufan.me
+convert:
+  # The image quality, from 1 to 100.
+  defaultQuality: 75
+  # The image format, one of jpg, png, webp, avif, gif.
+  defaultFormat: jpg
+s3:
+  # The region of AWS S3, use "auto" for the S3 compatible services.
+  region: auto
+  # The endpoint of the S3 compatible services, leave it blank for AWS S3.
+  endpoint: ""
+  bucket: ""
+  # Set the PANDORA_S3_ACCESS_KEY environment variable instead.
+  accessKey: ""
+  # Set the PANDORA_S3_ACCESS_SECRET_KEY environment variable instead.
+  accessSecretKey: ""
+  # Leave the keys blank for the AWS credential chain, like the AWS_ACCESS_KEY_ID env or this shared profile.
+  profile: ""
+`
+)
+
+var (
+	initCmd = &cobra.Command{
+		Use:   "init",
+		Short: "Initialize a project-local configuration file in the current directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			directory := filepath.Join(".", ProjectConfigDir)
+			configFile := filepath.Join(directory, ConfigFileName)
+			if _, err := os.Stat(configFile); err == nil && !forceInit {
+				return exitErrorf(ExitFailure, "The config file %s already exists, use --force for overwriting it.", configFile)
+			} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return exitErrorf(ExitFailure, "Failed to check the config file %s\nError: %v", configFile, err)
+			}
+
+			err := os.MkdirAll(directory, os.FileMode(0755))
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to create the config path %s\nError: %v", directory, err)
+			}
+			err = os.WriteFile(configFile, []byte(projectConfigTemplate), os.FileMode(0644))
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to write config file %s\nError: %v", configFile, err)
+			}
+			log.Printf("The project config file is generated into the [%v]\n", configFile)
+			return nil
+		},
+	}
+
+	forceInit = false
+)
+
+func init() {
+	initCmd.Flags().BoolVarP(&forceInit, "force", "", false, "Overwrite the existing config file")
+	rootCmd.AddCommand(initCmd)
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	// LogQuiet only shows the errors, the warnings and the final summaries.
+	LogQuiet = "quiet"
+	// LogNormal also shows the progress like the uploaded files, the skipped files are hidden.
+	LogNormal = "normal"
+	// LogVerbose shows everything including the per-file decisions.
+	LogVerbose = "verbose"
+
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&logFile, "log-file", "", "", "Append the log output to the given file in addition to stderr")
+	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "", LogNormal, "The log verbosity, one of quiet, normal and verbose")
+	rootCmd.PersistentFlags().BoolVarP(&noColor, "no-color", "", false, "Disable the colored log output, it's also disabled by the NO_COLOR env or without a terminal")
+}
+
+var (
+	logFile  = ""
+	logLevel = LogNormal
+	noColor  = false
+	// logColor colors the errors, warnings and successes logged on the terminal.
+	logColor = false
+
+	colorPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+)
+
+// setupLogLevel checks the --log-level flag before running the command.
+func setupLogLevel() error {
+	if logLevel != LogQuiet && logLevel != LogNormal && logLevel != LogVerbose {
+		return exitErrorf(ExitConfig, "Invalid log level %s, it should be one of %s, %s and %s", logLevel, LogQuiet, LogNormal, LogVerbose)
+	}
+	return nil
+}
+
+// infof logs the progress like the uploaded files, it's hidden in the quiet level.
+// The errors and the final summaries are logged by the log.Printf for always showing them.
+func infof(format string, v ...any) {
+	if logLevel != LogQuiet {
+		log.Printf(format, v...)
+	}
+}
+
+// debugf logs the per-file decisions like the skipped files, it's only shown in the verbose level.
+func debugf(format string, v ...any) {
+	if logLevel == LogVerbose {
+		log.Printf(format, v...)
+	}
+}
+
+// errorf logs the failures in red, it's shown in all the levels.
+func errorf(format string, v ...any) {
+	logColored(colorRed, format, v...)
+}
+
+// warnf logs the skipped steps and the fallbacks in yellow, it's shown in all the levels.
+func warnf(format string, v ...any) {
+	logColored(colorYellow, format, v...)
+}
+
+// successf logs the final summaries of the succeeded operations in green, it's shown in all the levels.
+func successf(format string, v ...any) {
+	logColored(colorGreen, format, v...)
+}
+
+func logColored(color, format string, v ...any) {
+	message := fmt.Sprintf(format, v...)
+	if logColor {
+		message = color + strings.TrimSuffix(message, "\n") + colorReset
+	}
+	log.Print(message)
+}
+
+// setupColor colors the log output on the terminal unless it's disabled by the --no-color or the NO_COLOR env.
+func setupColor() {
+	logColor = !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr)
+}
+
+// plainWriter strips the colors from the log output, the log file never receives the escape codes.
+type plainWriter struct {
+	writer io.Writer
+}
+
+func (p plainWriter) Write(b []byte) (int, error) {
+	if _, err := p.writer.Write(colorPattern.ReplaceAll(b, nil)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// setupLogging tees the log output into the log file from the flag or the config file.
+// The existing log file will be rotated into a ".old" file once it exceeds the configured max size.
+func setupLogging(config *PandoraConfig) error {
+	path := logFile
+	if path == "" {
+		path = config.Log.File
+	}
+	if path == "" {
+		return nil
+	}
+
+	if stat, err := os.Stat(path); err == nil && config.Log.MaxSize > 0 && stat.Size() >= config.Log.MaxSize {
+		err = os.Rename(path, path+".old")
+		if err != nil {
+			return exitErrorf(ExitFailure, "Failed to rotate the log file %s\nError: %v", path, err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.FileMode(0644))
+	if err != nil {
+		return exitErrorf(ExitFailure, "Failed to open the log file %s\nError: %v", path, err)
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, plainWriter{writer: file}))
+	log.Printf("Start executing [%s]\n", strings.Join(os.Args, " "))
+	return nil
+}
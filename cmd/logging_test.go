@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestLogColored(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	color, level := logColor, logLevel
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+		logColor, logLevel = color, level
+	}()
+	logLevel = LogNormal
+
+	tests := []struct {
+		name  string
+		color bool
+		logf  func(string, ...any)
+		want  string
+	}{
+		{"error", true, errorf, colorRed + "Upload cat.jpg" + colorReset + "\n"},
+		{"warning", true, warnf, colorYellow + "Upload cat.jpg" + colorReset + "\n"},
+		{"success", true, successf, colorGreen + "Upload cat.jpg" + colorReset + "\n"},
+		{"info is never colored", true, infof, "Upload cat.jpg\n"},
+		{"colors disabled", false, errorf, "Upload cat.jpg\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			logColor = tt.color
+			tt.logf("Upload %s", "cat.jpg")
+			if buf.String() != tt.want {
+				t.Errorf("The log output is %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainWriter(t *testing.T) {
+	var buf bytes.Buffer
+	line := colorRed + "Failed to upload cat.jpg\nError: timeout" + colorReset + "\n"
+	n, err := plainWriter{writer: &buf}.Write([]byte(line))
+	if err != nil || n != len(line) {
+		t.Fatalf("Write() = %d, %v, want %d", n, err, len(line))
+	}
+	if want := "Failed to upload cat.jpg\nError: timeout\n"; buf.String() != want {
+		t.Errorf("The log file got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// ImageManifest describes the variants generated from a single source for building the <picture> element.
+type ImageManifest struct {
+	Source string `json:"source"`
+	// The placeholder fields chosen by the metadata.blurFormat.
+	Placeholder
+	Variants []ImageVariant `json:"variants"`
+}
+
+// ImageVariant is a generated image of the source in a format and size, the URL is empty if it isn't uploaded.
+type ImageVariant struct {
+	Format  string `json:"format"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Density string `json:"density,omitempty"`
+	Path    string `json:"path"`
+	URL     string `json:"url,omitempty"`
+}
+
+// imageManifest is created by the --manifest flag, nothing is recorded if it's nil.
+var imageManifest *ImageManifest
+
+// Add records the variant, it's safe for the nil manifest.
+func (m *ImageManifest) Add(variant ImageVariant) {
+	if m != nil {
+		m.Variants = append(m.Variants, variant)
+	}
+}
+
+// SetURL links the variant saved in the path to its uploaded URL.
+func (m *ImageManifest) SetURL(path, url string) {
+	if m == nil {
+		return
+	}
+	for i := range m.Variants {
+		if m.Variants[i].Path == path {
+			m.Variants[i].URL = url
+		}
+	}
+}
+
+// Write saves the manifest in JSON into the file, or prints it to stdout if the file is -.
+func (m *ImageManifest) Write(file string) error {
+	if m == nil {
+		return nil
+	}
+	if m.Variants == nil {
+		m.Variants = []ImageVariant{}
+	}
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	if file == "-" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	return os.WriteFile(file, content, os.FileMode(0644))
+}
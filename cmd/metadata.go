@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The metadata could be split into multiple shard files for large galleries. The shards are placed
+// in the images/metadata directory and an images/metadata/index.json lists all the shards:
+//
+//	[{"name": "2019", "file": "images/metadata/2019.json", "count": 42}]
+//
+// The frontend should load the index file first and fetch the shards on demand.
+const (
+	ImageMetadataDir   = "images/metadata"
+	ImageMetadataIndex = "images/metadata/index.json"
+
+	// ShardNone keeps all the metadata in a single images/metadata.json file.
+	ShardNone = "none"
+	// ShardYear splits the metadata by the year directory, like images/metadata/2019.json.
+	ShardYear = "year"
+	// ShardDirectory splits the metadata by the parent directory, like images/metadata/images-2019-05.json.
+	ShardDirectory = "directory"
+
+	// ShardOther is the shard name for the images which couldn't be grouped by year.
+	ShardOther = "other"
+)
+
+var yearPattern = regexp.MustCompile(`^\d{4}$`)
+
+// MetadataShard is the entry of the metadata index file.
+type MetadataShard struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Count int    `json:"count"`
+}
+
+// isValidShard checks the sync.metadataShard in config.
+func isValidShard(shard string) bool {
+	return shard == "" || shard == ShardNone || shard == ShardYear || shard == ShardDirectory
+}
+
+// ShardMetadata partitions the metadata by the given shard scheme.
+func ShardMetadata(metas []ImageMetadata, shard string) map[string][]ImageMetadata {
+	shards := map[string][]ImageMetadata{}
+	for _, meta := range metas {
+		name := shardName(meta.Slug, shard)
+		shards[name] = append(shards[name], meta)
+	}
+	return shards
+}
+
+func shardName(slug, shard string) string {
+	dir := strings.Trim(path.Dir(slug), "/")
+	if shard == ShardDirectory {
+		return strings.ReplaceAll(dir, "/", "-")
+	}
+
+	// The slug is like /images/2019/05/2019050902101874.jpg, the year is the second segment.
+	segments := strings.Split(dir, "/")
+	if len(segments) > 1 && yearPattern.MatchString(segments[1]) {
+		return segments[1]
+	}
+	return ShardOther
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DownloadMetadata loads the image metadata from the bucket, the shards are merged if they are enabled.
+// The other layout is tried if the configured one doesn't exist, because the shard scheme may be changed.
+func DownloadMetadata(client *BucketClient, config *PandoraConfig) ([]ImageMetadata, error) {
+	loaders := []func(*BucketClient) ([]ImageMetadata, error){downloadSingleMetadata, downloadShardedMetadata}
+	if config.Sync.MetadataShard != "" && config.Sync.MetadataShard != ShardNone {
+		loaders[0], loaders[1] = loaders[1], loaders[0]
+	}
+
+	metas, err := loaders[0](client)
+	if err == nil {
+		return metas, nil
+	}
+	if fallback, e := loaders[1](client); e == nil {
+		return fallback, nil
+	}
+	return nil, err
+}
+
+func downloadSingleMetadata(client *BucketClient) ([]ImageMetadata, error) {
+	var metas []ImageMetadata
+	err := downloadMetadataFile(client, ImageMetadataFile, &metas)
+	return metas, err
+}
+
+func downloadShardedMetadata(client *BucketClient) ([]ImageMetadata, error) {
+	var index []MetadataShard
+	err := downloadMetadataFile(client, ImageMetadataIndex, &index)
+	if err != nil {
+		return nil, err
+	}
+	var metas []ImageMetadata
+	for _, shard := range index {
+		var shardMetas []ImageMetadata
+		err = downloadMetadataFile(client, shard.File, &shardMetas)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the metadata shard %s: %w", shard.File, err)
+		}
+		metas = append(metas, shardMetas...)
+	}
+	return metas, nil
+}
+
+func downloadMetadataFile(client *BucketClient, key string, value any) error {
+	content, err := client.DownloadObject(operationContext, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, value)
+}
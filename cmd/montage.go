@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	montageCmd.Flags().IntVarP(&montageColumns, "columns", "", 4, "The number of thumbnails in a row")
+	montageCmd.Flags().IntVarP(&montageCellSize, "cell", "", 320, "The size of the square cell for each thumbnail")
+	montageCmd.Flags().IntVarP(&montagePadding, "padding", "", 8, "The padding between the thumbnails")
+	montageCmd.Flags().StringVarP(&montageBackground, "background", "", "#ffffff", "The background color in hex format")
+	montageCmd.Flags().StringVarP(&montageOutput, "output", "o", "montage.jpg", "The montage image file path, the extension decides the format")
+	montageCmd.Flags().IntVarP(&montageQuality, "quality", "q", DefaultQuality, "The montage image quality")
+
+	rootCmd.AddCommand(montageCmd)
+}
+
+var (
+	montageCmd = &cobra.Command{
+		Use:   "montage <dir>",
+		Short: "Generate a contact sheet of all the images in the given directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if montageColumns <= 0 || montageCellSize <= 0 || montagePadding < 0 {
+				return exitErrorf(ExitFailure, "The columns and cell size should be positive, the padding shouldn't be negative")
+			}
+			if !isValidQuality(montageQuality) {
+				return exitErrorf(ExitFailure, "Invalid image quality %d, it should be between %d and %d", montageQuality, MinQuality, MaxQuality)
+			}
+			ok, format := isSupportedImage(montageOutput)
+			if !ok {
+				return exitErrorf(ExitFailure, "Unsupported montage format %s, only supports %s", format, supportedFormats())
+			}
+			if t := imageType(format); t == bimg.UNKNOWN || t == bimg.SVG {
+				return exitErrorf(ExitFailure, "The montage couldn't be saved in the %s format", format)
+			}
+			background, err := parseHexColor(montageBackground)
+			if err != nil {
+				return exitErrorf(ExitFailure, "Invalid background color %s\nError: %v", montageBackground, err)
+			}
+
+			thumbnails, err := loadThumbnails(args[0], montageCellSize)
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to read directory %v\nError: %v", args[0], err)
+			}
+			if len(thumbnails) == 0 {
+				return exitErrorf(ExitFailure, "No image is found in the directory %s", args[0])
+			}
+
+			grid, err := vipsArrayJoin(thumbnails, montageColumns, montageCellSize, montagePadding, background)
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to compose the montage image: %v", err)
+			}
+			content, err := bimg.NewImage(grid).Process(bimg.Options{Quality: montageQuality, Type: imageType(format)})
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to convert the montage image: %v", err)
+			}
+			err = os.WriteFile(montageOutput, content, os.FileMode(0644))
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to save the montage image: %v", err)
+			}
+			log.Printf("The montage of %d images is saved into the [%v]\n", len(thumbnails), montageOutput)
+			return nil
+		},
+	}
+
+	montageColumns    = 4
+	montageCellSize   = 320
+	montagePadding    = 8
+	montageBackground = "#ffffff"
+	montageOutput     = "montage.jpg"
+	montageQuality    = DefaultQuality
+)
+
+// loadThumbnails resizes every image in the directory to fit in the square cell, keeping the aspect ratio.
+// The thumbnails are in the lossless PNG.
+func loadThumbnails(directory string, cell int) ([][]byte, error) {
+	files, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var thumbnails [][]byte
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		if ok, _ := isSupportedImage(file.Name()); !ok {
+			continue
+		}
+
+		path := filepath.Join(directory, file.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			errorf("Failed to read the image %v, skip it", path)
+			continue
+		}
+		if content, err = autoOrient(content); err != nil {
+			errorf("Failed to rotate the image %v, skip it\nError: %v", path, err)
+			continue
+		}
+		img := bimg.NewImage(content)
+		size, err := img.Size()
+		if err != nil {
+			errorf("Failed to read the image size for %v, skip it", path)
+			continue
+		}
+		width, height := cell, cell
+		if size.Width > size.Height {
+			height = max(1, size.Height*cell/size.Width)
+		} else {
+			width = max(1, size.Width*cell/size.Height)
+		}
+		thumb, err := img.Process(bimg.Options{Width: width, Height: height, Type: bimg.PNG})
+		if err != nil {
+			errorf("Failed to resize the image %v, skip it\nError: %v", path, err)
+			continue
+		}
+		thumbnails = append(thumbnails, thumb)
+	}
+	return thumbnails, nil
+}
+
+// parseHexColor parses the color in #rrggbb or #rgb format.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = strings.Repeat(hex[0:1], 2) + strings.Repeat(hex[1:2], 2) + strings.Repeat(hex[2:3], 2)
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("the color should be in #rrggbb format")
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
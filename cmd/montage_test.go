@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testPNG encodes the image filled in the color into png.
+func testPNG(t *testing.T, width, height int, c color.Color) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(width, height, func(_, _ int) color.Color { return c })); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVipsArrayJoin(t *testing.T) {
+	requireVips(t)
+	red, blue, white := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}, color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	thumbnails := [][]byte{
+		testPNG(t, 100, 50, red),
+		testPNG(t, 50, 100, blue),
+		// The transparent thumbnail is flattened on the background.
+		testPNG(t, 100, 100, color.RGBA{}),
+	}
+	grid, err := vipsArrayJoin(thumbnails, 2, 100, 10, white)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(grid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := decoded.Bounds().Size(); size != image.Pt(230, 230) {
+		t.Fatalf("The grid is %v, want 2 cells with the padding on both sides", size)
+	}
+
+	tests := []struct {
+		name string
+		x, y int
+		want color.RGBA
+	}{
+		{"border", 5, 5, white},
+		{"above the wide thumbnail", 60, 20, white},
+		{"wide thumbnail", 60, 60, red},
+		{"beside the tall thumbnail", 130, 60, white},
+		{"tall thumbnail", 170, 60, blue},
+		{"transparent thumbnail", 60, 170, white},
+		{"empty cell", 170, 170, white},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, g, b, _ := decoded.At(tt.x, tt.y).RGBA()
+			if got := (color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 255}); got != tt.want {
+				t.Errorf("The pixel (%d, %d) is %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
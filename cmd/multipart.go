@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	multipartCmd = &cobra.Command{
+		Use:   "multipart",
+		Short: "Manage the incomplete multipart uploads which still cost the storage",
+	}
+
+	multipartListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the incomplete multipart uploads in the bucket",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := ReadConfig()
+			if err != nil {
+				return err
+			}
+			if err := setupLogging(config); err != nil {
+				return err
+			}
+			client, err := newBucketClient(config)
+			if err != nil {
+				return err
+			}
+
+			uploads, err := client.ListMultipartUploads(operationContext)
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to list the multipart uploads.\nError: %v", err)
+			}
+			for _, upload := range uploads {
+				fmt.Printf("%s\t%s\t%s\t%d\n", aws.ToString(upload.Key), aws.ToString(upload.UploadId),
+					aws.ToTime(upload.Initiated).Format(time.RFC3339), client.MultipartUploadSize(operationContext, upload))
+			}
+			infof("Found %d incomplete multipart uploads", len(uploads))
+			return nil
+		},
+	}
+
+	multipartAbortCmd = &cobra.Command{
+		Use:   "abort",
+		Short: "Abort the incomplete multipart uploads and delete their uploaded parts",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := ReadConfig()
+			if err != nil {
+				return err
+			}
+			if err := setupLogging(config); err != nil {
+				return err
+			}
+			client, err := newBucketClient(config)
+			if err != nil {
+				return err
+			}
+
+			uploads, err := client.ListMultipartUploads(operationContext)
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to list the multipart uploads.\nError: %v", err)
+			}
+			if multipartOlderThan > 0 {
+				deadline := time.Now().Add(-multipartOlderThan)
+				var filtered []types.MultipartUpload
+				for _, upload := range uploads {
+					if aws.ToTime(upload.Initiated).Before(deadline) {
+						filtered = append(filtered, upload)
+					}
+				}
+				uploads = filtered
+			}
+			if len(uploads) == 0 {
+				log.Println("No multipart upload needs to be aborted")
+				return nil
+			}
+
+			if multipartDryRun {
+				report := &DryRunReport{}
+				for _, upload := range uploads {
+					report.Add("abort", aws.ToString(upload.Key), client.MultipartUploadSize(operationContext, upload))
+				}
+				report.Print(os.Stdout)
+				return nil
+			}
+
+			// Aborting all the uploads may break the running uploads on the other machines.
+			if multipartOlderThan == 0 && !multipartYes && !confirm(fmt.Sprintf("Abort all the %d multipart uploads?", len(uploads))) {
+				log.Println("Nothing is aborted")
+				return nil
+			}
+
+			aborted := 0
+			for _, upload := range uploads {
+				if err := client.AbortMultipartUpload(operationContext, upload); err != nil {
+					errorf("Failed to abort the multipart upload %s of [%v]\nError: %v", aws.ToString(upload.UploadId), aws.ToString(upload.Key), err)
+					continue
+				}
+				aborted++
+			}
+			successf("Successfully abort %d multipart uploads", aborted)
+			return nil
+		},
+	}
+
+	multipartOlderThan time.Duration
+	multipartDryRun    = false
+	multipartYes       = false
+)
+
+func init() {
+	multipartAbortCmd.Flags().DurationVarP(&multipartOlderThan, "older-than", "", 0, "Only abort the uploads initiated before the given duration like 24h, 0 for all the uploads")
+	multipartAbortCmd.Flags().BoolVarP(&multipartDryRun, "dry-run", "", false, "List the uploads which would be aborted without aborting them")
+	multipartAbortCmd.Flags().BoolVarP(&multipartYes, "yes", "y", false, "Abort all the uploads without the confirmation")
+	multipartCmd.AddCommand(multipartListCmd, multipartAbortCmd)
+	rootCmd.AddCommand(multipartCmd)
+}
+
+// confirm asks the question on the terminal, only y or yes is accepted.
+func confirm(question string) bool {
+	var answer string
+	fmt.Printf("%s [y/N] ", question)
+	_, _ = fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// ListMultipartUploads lists all the incomplete multipart uploads in the bucket.
+func (bucket *BucketClient) ListMultipartUploads(ctx context.Context) ([]types.MultipartUpload, error) {
+	var uploads []types.MultipartUpload
+	paginator := s3.NewListMultipartUploadsPaginator(bucket.Client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket.Bucket),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, bucket.explainRegionRedirect(ctx, err)
+		}
+		uploads = append(uploads, output.Uploads...)
+	}
+	return uploads, nil
+}
+
+// MultipartUploadSize sums the sizes of the uploaded parts, it's 0 if the parts couldn't be listed.
+func (bucket *BucketClient) MultipartUploadSize(ctx context.Context, upload types.MultipartUpload) int64 {
+	var size int64
+	paginator := s3.NewListPartsPaginator(bucket.Client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket.Bucket),
+		Key:      upload.Key,
+		UploadId: upload.UploadId,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return size
+		}
+		for _, part := range output.Parts {
+			size += aws.ToInt64(part.Size)
+		}
+	}
+	return size
+}
+
+// AbortMultipartUpload aborts the upload, the uploaded parts are deleted by S3.
+func (bucket *BucketClient) AbortMultipartUpload(ctx context.Context, upload types.MultipartUpload) error {
+	_, err := bucket.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket.Bucket),
+		Key:      upload.Key,
+		UploadId: upload.UploadId,
+	})
+	return err
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sort"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// BlurWebP is the default metadata.blurFormat, a tiny WebP image in the base64 data URL.
+	BlurWebP = "webp"
+	// BlurHashFormat is the compact BlurHash string decoded by the frontend.
+	BlurHashFormat = "blurhash"
+	// ThumbHashFormat is the base64 ThumbHash, it keeps the colors better and supports the transparency.
+	ThumbHashFormat = "thumbhash"
+	// DominantColorFormat is the average color in hex like #aabbcc for the skeleton screens.
+	DominantColorFormat = "color"
+)
+
+// Placeholder is the fields generated by a PlaceholderGenerator, they are merged into the ImageMetadata.
+type Placeholder struct {
+	BlurDataURL string `json:"blurDataURL,omitempty"`
+	BlurHash    string `json:"blurHash,omitempty"`
+	ThumbHash   string `json:"thumbHash,omitempty"`
+	// The average color of the opaque pixels in hex, it's empty if the image is fully transparent.
+	DominantColor string `json:"dominantColor,omitempty"`
+}
+
+// merge copies the generated fields of the other placeholder.
+func (p *Placeholder) merge(other Placeholder) {
+	if other.BlurDataURL != "" {
+		p.BlurDataURL = other.BlurDataURL
+	}
+	if other.BlurHash != "" {
+		p.BlurHash = other.BlurHash
+	}
+	if other.ThumbHash != "" {
+		p.ThumbHash = other.ThumbHash
+	}
+	if other.DominantColor != "" {
+		p.DominantColor = other.DominantColor
+	}
+}
+
+// PlaceholderGenerator generates the placeholder shown before the image is loaded. The content is the
+// source image and the size is its decoded size. The bimg processing replaces the buffer of the image,
+// the generator should create its own bimg image from the content.
+type PlaceholderGenerator interface {
+	Generate(content []byte, size bimg.ImageSize) (Placeholder, error)
+}
+
+// placeholderGenerators are the generators keyed by the metadata.blurFormat in config.
+var placeholderGenerators = map[string]PlaceholderGenerator{
+	BlurWebP:            webpPlaceholder{},
+	BlurHashFormat:      blurHashPlaceholder{},
+	ThumbHashFormat:     thumbHashPlaceholder{},
+	DominantColorFormat: dominantColorPlaceholder{},
+}
+
+// blurFormat is the metadata.blurFormat in config, the BlurWebP is used if it's empty.
+var blurFormat = ""
+
+// placeholderGenerator returns the generators of the metadata.blurFormat, the formats could be
+// combined by commas like webp,blurhash.
+func placeholderGenerator() PlaceholderGenerator {
+	var generators multiPlaceholder
+	for _, format := range splitBlurFormat(blurFormat) {
+		if generator, ok := placeholderGenerators[format]; ok {
+			generators = append(generators, generator)
+		}
+	}
+	if len(generators) == 0 {
+		return placeholderGenerators[BlurWebP]
+	}
+	return generators
+}
+
+// placeholderFormat returns the metadata.blurFormat of the generated placeholders, it's recorded in the metadata.
+func placeholderFormat() string {
+	if formats := splitBlurFormat(blurFormat); len(formats) > 0 {
+		return strings.Join(formats, ",")
+	}
+	return BlurWebP
+}
+
+func splitBlurFormat(format string) []string {
+	var formats []string
+	for _, f := range strings.Split(format, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+func isValidBlurFormat(format string) bool {
+	for _, f := range splitBlurFormat(format) {
+		if _, ok := placeholderGenerators[f]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func supportedBlurFormats() string {
+	formats := make([]string, 0, len(placeholderGenerators))
+	for format := range placeholderGenerators {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return strings.Join(formats, ", ")
+}
+
+// multiPlaceholder merges the placeholders of the generators in order.
+type multiPlaceholder []PlaceholderGenerator
+
+func (generators multiPlaceholder) Generate(content []byte, size bimg.ImageSize) (Placeholder, error) {
+	var merged Placeholder
+	for _, generator := range generators {
+		placeholder, err := generator.Generate(content, size)
+		if err != nil {
+			return Placeholder{}, err
+		}
+		merged.merge(placeholder)
+	}
+	return merged, nil
+}
+
+// webpPlaceholder resizes the image into the BlurWidth wide WebP in the lowest quality.
+type webpPlaceholder struct{}
+
+func (webpPlaceholder) Generate(content []byte, size bimg.ImageSize) (Placeholder, error) {
+	options := bimg.Options{
+		Width:   BlurWidth,
+		Height:  size.Height * BlurWidth / size.Width,
+		Crop:    false,
+		Quality: 1,
+		Rotate:  0,
+		Type:    bimg.WEBP,
+	}
+	b, err := bimg.NewImage(content).Process(options)
+	if err != nil {
+		return Placeholder{}, err
+	}
+	return Placeholder{BlurDataURL: fmt.Sprintf(BlurDataFormat, base64.StdEncoding.EncodeToString(b))}, nil
+}
+
+// blurHashPlaceholder encodes the downscaled pixels into the BlurHash in 4x3 or 3x4 components.
+type blurHashPlaceholder struct{}
+
+func (blurHashPlaceholder) Generate(content []byte, size bimg.ImageSize) (Placeholder, error) {
+	pixels, err := decodePreview(content, size, blurHashPreviewWidth)
+	if err != nil {
+		return Placeholder{}, err
+	}
+	x, y := 4, 3
+	if size.Height > size.Width {
+		x, y = 3, 4
+	}
+	return Placeholder{BlurHash: blurHash(pixels, x, y)}, nil
+}
+
+// thumbHashPlaceholder encodes the RGBA pixels of the thumbnail within 100x100 into the ThumbHash.
+type thumbHashPlaceholder struct{}
+
+func (thumbHashPlaceholder) Generate(content []byte, size bimg.ImageSize) (Placeholder, error) {
+	width := thumbHashMaxSize
+	if size.Height > size.Width {
+		width = max(size.Width*thumbHashMaxSize/size.Height, 1)
+	}
+	pixels, err := decodePreview(content, size, width)
+	if err != nil {
+		return Placeholder{}, err
+	}
+	return Placeholder{ThumbHash: base64.StdEncoding.EncodeToString(thumbHash(pixels))}, nil
+}
+
+// dominantColorPlaceholder averages the pixels of the downscaled image weighted by their alpha,
+// the fully transparent pixels are ignored.
+type dominantColorPlaceholder struct{}
+
+func (dominantColorPlaceholder) Generate(content []byte, size bimg.ImageSize) (Placeholder, error) {
+	pixels, err := decodePreview(content, size, blurHashPreviewWidth)
+	if err != nil {
+		return Placeholder{}, err
+	}
+	var r, g, b, weight float64
+	bounds := pixels.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(pixels.At(x, y)).(color.NRGBA)
+			alpha := float64(c.A) / 255
+			r += float64(c.R) * alpha
+			g += float64(c.G) * alpha
+			b += float64(c.B) * alpha
+			weight += alpha
+		}
+	}
+	if weight == 0 {
+		return Placeholder{}, nil
+	}
+	return Placeholder{DominantColor: fmt.Sprintf("#%02x%02x%02x", int(r/weight+0.5), int(g/weight+0.5), int(b/weight+0.5))}, nil
+}
+
+// decodePreview downscales the image into the given width and decodes its pixels.
+func decodePreview(content []byte, size bimg.ImageSize, width int) (image.Image, error) {
+	height := max(size.Height*width/size.Width, 1)
+	preview, err := bimg.NewImage(content).Process(bimg.Options{Width: width, Height: height, Force: true, Type: bimg.PNG})
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(preview))
+}
@@ -0,0 +1,14 @@
+package cmd
+
+import "testing"
+
+func TestPlaceholderFormat(t *testing.T) {
+	original := blurFormat
+	defer func() { blurFormat = original }()
+	for format, want := range map[string]string{"": BlurWebP, "blurhash": "blurhash", " webp , blurhash ": "webp,blurhash"} {
+		blurFormat = format
+		if got := placeholderFormat(); got != want {
+			t.Errorf("placeholderFormat() of %q = %q, want %q", format, got, want)
+		}
+	}
+}
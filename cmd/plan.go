@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The actions of the planned items.
+const (
+	PlanUpload    = "upload"
+	PlanOverwrite = "overwrite"
+	PlanDelete    = "delete"
+)
+
+// SyncPlan collects the uploads and the prunes computed by the sync, they are reviewed before being executed.
+type SyncPlan struct {
+	lock  sync.Mutex
+	items []*SyncPlanItem
+}
+
+// SyncPlanItem is a planned upload of the local file, or a planned delete of the orphaned object.
+type SyncPlanItem struct {
+	File     string
+	Slug     string
+	Key      string
+	Size     int64
+	Metadata map[string]string
+	// Image is the metadata of the image file, it's nil for the other files.
+	Image *ImageMetadata
+	// Action is the upload of the new file, the overwrite of the changed object or the delete of the orphaned object.
+	Action   string
+	Selected bool
+}
+
+// Add records the planned item, it's selected by default.
+func (plan *SyncPlan) Add(item SyncPlanItem) {
+	plan.lock.Lock()
+	defer plan.lock.Unlock()
+	item.Selected = true
+	plan.items = append(plan.items, &item)
+}
+
+// isTerminal checks the file is an interactive terminal instead of a pipe or a regular file.
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Review shows the plan in the terminal UI for toggling the items. The uploads are listed before the deletes.
+// It returns false if the user quits without confirming the plan.
+func (plan *SyncPlan) Review(in io.Reader, out io.Writer) bool {
+	model := plan.model()
+	if _, err := tea.NewProgram(model, tea.WithInput(in), tea.WithOutput(out)).Run(); err != nil {
+		errorf("Failed to show the sync plan on the terminal.\nError: %v", err)
+		return false
+	}
+	return model.confirmed
+}
+
+// model sorts the items for reviewing them in the terminal UI, the deletes are listed after the uploads.
+func (plan *SyncPlan) model() *planModel {
+	sort.SliceStable(plan.items, func(i, j int) bool {
+		if a, b := plan.items[i].Action == PlanDelete, plan.items[j].Action == PlanDelete; a != b {
+			return b
+		}
+		return plan.items[i].Key < plan.items[j].Key
+	})
+	return &planModel{items: plan.items}
+}
+
+// planModel is the terminal UI of the plan, the cursor item is toggled by the space key.
+type planModel struct {
+	items []*SyncPlanItem
+	// cursor is the focused item, offset is the first visible item in the terminal height.
+	cursor, offset, height int
+	confirmed              bool
+	done                   bool
+}
+
+func (m *planModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *planModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			m.cursor = max(m.cursor-1, 0)
+		case "down", "j":
+			m.cursor = min(m.cursor+1, len(m.items)-1)
+		case " ", "x":
+			m.items[m.cursor].Selected = !m.items[m.cursor].Selected
+		case "a", "n":
+			for _, item := range m.items {
+				item.Selected = msg.String() == "a"
+			}
+		case "enter":
+			m.confirmed, m.done = true, true
+			return m, tea.Quit
+		case "q", "esc", "ctrl+c":
+			m.done = true
+			return m, tea.Quit
+		}
+	}
+
+	// Scroll the list for keeping the cursor visible.
+	if rows := m.rows(); m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if m.cursor >= m.offset+rows {
+		m.offset = m.cursor - rows + 1
+	}
+	return m, nil
+}
+
+// rows is the number of the visible items, the header and the footer take two lines.
+func (m *planModel) rows() int {
+	if m.height <= 2 {
+		return len(m.items)
+	}
+	return m.height - 2
+}
+
+func (m *planModel) View() string {
+	var b strings.Builder
+	counts := map[string]int{}
+	for _, item := range m.items {
+		if item.Selected {
+			counts[item.Action]++
+		}
+	}
+	_, _ = fmt.Fprintf(&b, "Selected %d uploads, %d overwrites and %d deletes of %d planned items\n",
+		counts[PlanUpload], counts[PlanOverwrite], counts[PlanDelete], len(m.items))
+
+	end := min(m.offset+m.rows(), len(m.items))
+	for i := m.offset; i < end; i++ {
+		item := m.items[i]
+		cursor, check := " ", " "
+		if i == m.cursor && !m.done {
+			cursor = ">"
+		}
+		if item.Selected {
+			check = "x"
+		}
+		if item.Action == PlanDelete {
+			_, _ = fmt.Fprintf(&b, "%s [%s] %-9s %s\n", cursor, check, item.Action, item.Key)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%s [%s] %-9s %s (%d bytes)\n", cursor, check, item.Action, item.Key, item.Size)
+		}
+	}
+	if !m.done {
+		b.WriteString("up/down moves, space toggles, a for all, n for none, enter for executing the selected items, q for quitting")
+	}
+	return b.String()
+}
+
+// Execute uploads the selected files and deletes the selected orphans after the uploads.
+func (plan *SyncPlan) Execute(client *BucketClient, summary *SyncSummary) {
+	var orphans []string
+	for _, item := range plan.items {
+		if !item.Selected {
+			continue
+		}
+		if item.Action == PlanDelete {
+			orphans = append(orphans, item.Key)
+			continue
+		}
+		content, err := os.ReadFile(item.File)
+		if err != nil {
+			errorf("Failed to read the file %v content", item.File)
+			summary.Failed.Add(1)
+			continue
+		}
+		infof("Try to upload the file [%v] to the aws s3", item.File)
+		if err = client.UploadObject(operationContext, item.Key, content, item.Metadata); errors.Is(err, ErrObjectExists) {
+			debugf("Skip the file [%v], the object [%v] already exists", item.File, item.Key)
+		} else if isAuthError(err) {
+			summary.Abort(exitErrorf(ExitAuth, "The S3 credentials are rejected.\nError: %v", err))
+			return
+		} else if err != nil {
+			errorf("Failed to upload the file %v to s3", item.File)
+			summary.Failed.Add(1)
+			linkReport.AddObject(item.File, item.Key, item.Image, err)
+			continue
+		} else {
+			summary.Uploaded.Add(1)
+			summary.Bytes.Add(item.Size)
+		}
+		linkReport.AddObject(item.File, item.Key, item.Image, nil)
+	}
+	if len(orphans) > 0 {
+		deleteOrphans(client, orphans)
+	}
+}
+
+// Filter drops the metadata of the deselected files. The deselected overwrites keep
+// their existing metadata in the bucket, the deselected new files have no metadata.
+func (plan *SyncPlan) Filter(metas, existing []ImageMetadata) []ImageMetadata {
+	skipped := map[string]bool{}
+	for _, item := range plan.items {
+		if !item.Selected && item.Action != PlanDelete {
+			skipped[item.Slug] = true
+		}
+	}
+	previous := map[string]ImageMetadata{}
+	for _, meta := range existing {
+		previous[meta.Slug] = meta
+	}
+
+	filtered := make([]ImageMetadata, 0, len(metas))
+	for _, meta := range metas {
+		if !skipped[meta.Slug] {
+			filtered = append(filtered, meta)
+		} else if old, ok := previous[meta.Slug]; ok {
+			filtered = append(filtered, old)
+		}
+	}
+	return filtered
+}
+
+// confirmSyncPlan reviews the plan on the terminal and uploads the selected files. It returns
+// the metadata of the confirmed files, or false if the user quits without syncing anything.
+func confirmSyncPlan(client *BucketClient, config *PandoraConfig, metas []ImageMetadata, summary *SyncSummary) ([]ImageMetadata, bool) {
+	if len(syncPlan.items) == 0 {
+		successf("All the files are synced, nothing needs to be uploaded or pruned")
+		return metas, true
+	}
+	if !syncPlan.Review(os.Stdin, os.Stdout) {
+		return nil, false
+	}
+	syncPlan.Execute(client, summary)
+
+	existing, err := DownloadMetadata(client, config)
+	if err != nil {
+		warnf("Failed to load the existing image metadata, the deselected files are dropped from the metadata.\nError: %v", err)
+	}
+	return syncPlan.Filter(metas, existing), true
+}
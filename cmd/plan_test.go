@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func testSyncPlan() *SyncPlan {
+	plan := &SyncPlan{}
+	plan.Add(SyncPlanItem{Key: "images/old.jpg", Action: PlanDelete})
+	plan.Add(SyncPlanItem{Key: "images/b.jpg", Size: 20, Action: PlanOverwrite})
+	plan.Add(SyncPlanItem{Key: "images/a.jpg", Size: 10, Action: PlanUpload})
+	return plan
+}
+
+// pressKeys sends the keys to the model, the space and the carriage return are the space and enter keys.
+func pressKeys(model *planModel, keys string) bool {
+	for _, r := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}
+		switch r {
+		case ' ':
+			msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{r}}
+		case '\r':
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		}
+		if _, cmd := model.Update(msg); cmd != nil {
+			return model.confirmed
+		}
+	}
+	return false
+}
+
+func TestSyncPlanReview(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      string
+		confirmed bool
+		selected  []bool
+	}{
+		{"confirm all", "\r", true, []bool{true, true, true}},
+		{"toggle the delete", "jjx\r", true, []bool{true, true, false}},
+		{"select none and the first", "n \r", true, []bool{true, false, false}},
+		{"cursor stops at the end", "jjjjj \r", true, []bool{true, true, false}},
+		{"quit", "xq", false, []bool{false, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := testSyncPlan()
+			model := plan.model()
+			view := model.View()
+			if confirmed := pressKeys(model, tt.keys); confirmed != tt.confirmed {
+				t.Fatalf("The review returns %v, want %v", confirmed, tt.confirmed)
+			}
+
+			// The uploads are listed before the deletes.
+			keys := []string{"images/a.jpg", "images/b.jpg", "images/old.jpg"}
+			for i, item := range plan.items {
+				if item.Key != keys[i] || item.Selected != tt.selected[i] {
+					t.Errorf("The item %d is %s selected %v, want %s selected %v", i, item.Key, item.Selected, keys[i], tt.selected[i])
+				}
+			}
+			for _, line := range []string{"> [x] upload    images/a.jpg (10 bytes)", "overwrite images/b.jpg (20 bytes)", "delete    images/old.jpg\n"} {
+				if !strings.Contains(view, line) {
+					t.Errorf("The plan should list %q, got %q", line, view)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncPlanScroll(t *testing.T) {
+	plan := testSyncPlan()
+	model := plan.model()
+	model.Update(tea.WindowSizeMsg{Width: 80, Height: 4})
+	pressKeys(model, "jj")
+	view := model.View()
+	if strings.Contains(view, "images/a.jpg") || !strings.Contains(view, "> [x] delete    images/old.jpg") {
+		t.Errorf("The list should scroll to the cursor in the terminal height, got %q", view)
+	}
+}
+
+func TestSyncPlanFilter(t *testing.T) {
+	plan := testSyncPlan()
+	plan.items[0].Selected = false
+	plan.items[2].Slug, plan.items[2].Selected = "/images/a.jpg", false
+	metas := []ImageMetadata{{Slug: "/images/a.jpg", Width: 2}, {Slug: "/images/c.jpg"}}
+	existing := []ImageMetadata{{Slug: "/images/a.jpg", Width: 1}}
+
+	filtered := plan.Filter(metas, existing)
+	if len(filtered) != 2 || filtered[0].Width != 1 || filtered[1].Slug != "/images/c.jpg" {
+		t.Errorf("The deselected overwrite should keep the existing metadata, got %+v", filtered)
+	}
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+)
+
+var (
+	cpuProfile  = ""
+	memProfile  = ""
+	cpuFile     *os.File
+	profileOnce sync.Once
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&cpuProfile, "cpuprofile", "", "", "Write the pprof CPU profile of the run into the file")
+	rootCmd.PersistentFlags().StringVarP(&memProfile, "memprofile", "", "", "Write the pprof heap profile at the end of the run into the file")
+	_ = rootCmd.PersistentFlags().MarkHidden("cpuprofile")
+	_ = rootCmd.PersistentFlags().MarkHidden("memprofile")
+}
+
+// startProfiling starts the CPU profile, the profiles are flushed by stopProfiling
+// or when the process is interrupted.
+func startProfiling() error {
+	if cpuProfile == "" && memProfile == "" {
+		return nil
+	}
+	if cpuProfile != "" {
+		file, err := os.Create(cpuProfile)
+		if err != nil {
+			return exitErrorf(ExitFailure, "Failed to create the CPU profile %s\nError: %v", cpuProfile, err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			_ = file.Close()
+			return exitErrorf(ExitFailure, "Failed to start the CPU profile.\nError: %v", err)
+		}
+		cpuFile = file
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		warnf("Received %v, flush the profiles before exiting", sig)
+		stopProfiling()
+		os.Exit(ExitFailure)
+	}()
+	return nil
+}
+
+// stopProfiling flushes the CPU profile and writes the heap profile, it only works once.
+func stopProfiling() {
+	profileOnce.Do(func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			_ = cpuFile.Close()
+		}
+		if memProfile != "" {
+			file, err := os.Create(memProfile)
+			if err != nil {
+				errorf("Failed to create the heap profile %s\nError: %v", memProfile, err)
+				return
+			}
+			defer func() { _ = file.Close() }()
+			// Collect the garbage for the up-to-date allocation statistics.
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(file); err != nil {
+				errorf("Failed to write the heap profile.\nError: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"os"
+	"sync"
+
+	"github.com/h2non/bimg"
+	"github.com/spf13/cobra"
+)
+
+// repairHeaderBytes is the size of the range read for the image header, it covers the large EXIF segments.
+const repairHeaderBytes = 128 * 1024
+
+var (
+	metadataCmd = &cobra.Command{
+		Use:   "metadata",
+		Short: "Maintain the image metadata file in the bucket",
+	}
+
+	metadataRepairCmd = &cobra.Command{
+		Use:   "repair",
+		Short: "Correct the image dimensions in the metadata file by reading the image headers from the bucket",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := ReadConfig()
+			if err != nil {
+				return err
+			}
+			if err := setupLogging(config); err != nil {
+				return err
+			}
+			if err := setupVips(config); err != nil {
+				return err
+			}
+			blurFormat = config.Metadata.BlurFormat
+			client, err := newBucketClient(config)
+			if err != nil {
+				return err
+			}
+			if repairDryRun {
+				client.DryRun = &DryRunReport{}
+				defer client.DryRun.Print(os.Stdout)
+			}
+
+			metas, err := DownloadMetadata(client, config)
+			if err != nil {
+				return exitErrorf(ExitFailure, "Failed to download the image metadata\nError: %v", err)
+			}
+			infof("Repair %d images in the metadata file", len(metas))
+
+			repaired := RepairMetadata(client, metas)
+			if repaired == 0 {
+				successf("All the image metadata are correct")
+				return nil
+			}
+			UploadMetadata(client, config, metas)
+			successf("Successfully repair %d images in the metadata file", repaired)
+			return nil
+		},
+	}
+
+	repairBlur   = false
+	repairDryRun = false
+)
+
+func init() {
+	metadataRepairCmd.Flags().BoolVarP(&repairBlur, "blur", "", false, "Regenerate the blur placeholders, the whole images are downloaded")
+	metadataRepairCmd.Flags().BoolVarP(&repairDryRun, "dry-run", "", false, "List the repaired images and metadata files without uploading them")
+	metadataCmd.AddCommand(metadataRepairCmd)
+	rootCmd.AddCommand(metadataCmd)
+}
+
+// RepairMetadata corrects the metadata entries in place with the real dimensions of the uploaded images.
+// It returns the count of the repaired entries.
+func RepairMetadata(client *BucketClient, metas []ImageMetadata) int {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		repaired int
+	)
+	tokens := make(chan struct{}, verifyConcurrency)
+	for i := range metas {
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func(meta *ImageMetadata) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			if repairImage(client, meta) {
+				mu.Lock()
+				repaired++
+				mu.Unlock()
+			}
+		}(&metas[i])
+	}
+	wg.Wait()
+
+	return repaired
+}
+
+// repairImage reads the image header by a range request, the whole image is downloaded if the header
+// isn't enough for reading the size or the blur placeholder should be regenerated.
+func repairImage(client *BucketClient, meta *ImageMetadata) bool {
+	key := meta.objectKey(client)
+	var content []byte
+	var err error
+	if !repairBlur {
+		content, err = client.DownloadObjectRange(operationContext, key, repairHeaderBytes)
+	}
+	size, e := bimg.NewImage(content).Size()
+	if repairBlur || err != nil || e != nil {
+		content, err = client.DownloadObject(operationContext, key)
+		if err != nil {
+			errorf("Failed to download the image [%v]\nError: %v", key, err)
+			return false
+		}
+		if size, err = bimg.NewImage(content).Size(); err != nil {
+			errorf("Failed to read the image size for [%v]\nError: %v", key, err)
+			return false
+		}
+	}
+
+	changed := size.Width != meta.Width || size.Height != meta.Height
+	if changed {
+		infof("Repair the dimensions for [%v] from %dx%d to %dx%d", key, meta.Width, meta.Height, size.Width, size.Height)
+		meta.Width, meta.Height = size.Width, size.Height
+	}
+	if repairBlur {
+		if generated := ReadImageMetadata(key, meta.Slug, content); generated != nil && (generated.Placeholder != meta.Placeholder || generated.BlurFormat != meta.BlurFormat) {
+			meta.Placeholder, meta.BlurFormat = generated.Placeholder, generated.BlurFormat
+			changed = true
+		}
+	}
+	if changed && client.DryRun != nil {
+		client.DryRun.Add("repair", meta.Slug, 0)
+	}
+	return changed
+}
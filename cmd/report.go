@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ReportEntry is a generated image in the --report file, the failed one has the error instead of the link.
+type ReportEntry struct {
+	Source string `json:"source"`
+	Output string `json:"output,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LinkReport collects the generated image links of the run for the static site generators.
+// The synced objects are linked by the base URL.
+type LinkReport struct {
+	BaseURL string
+	lock    sync.Mutex
+	entries []ReportEntry
+}
+
+// linkReport is created by the --report flag, nothing is recorded if it's nil.
+var linkReport *LinkReport
+
+// Add records the entry, it's safe for the concurrent syncs and the nil report.
+func (r *LinkReport) Add(entry ReportEntry) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// AddObject records the synced image by its object key, or the failure of uploading the file.
+func (r *LinkReport) AddObject(source, key string, meta *ImageMetadata, err error) {
+	if r == nil || meta == nil && err == nil {
+		return
+	}
+	entry := ReportEntry{Source: source, Output: key}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.URL, _ = url.JoinPath(r.BaseURL, key)
+	}
+	if meta != nil {
+		entry.Width, entry.Height = meta.Width, meta.Height
+	}
+	r.Add(entry)
+}
+
+// Write saves the entries in a JSON array, or one JSON object per line if the file ends with .jsonl.
+func (r *LinkReport) Write(path string) error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	enc := json.NewEncoder(file)
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		for _, entry := range r.entries {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	enc.SetIndent("", "  ")
+	entries := r.entries
+	if entries == nil {
+		entries = []ReportEntry{}
+	}
+	return enc.Encode(entries)
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -10,11 +13,80 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "pandora",
 	Short: "A set of useful tools for writing in weblog",
+	// The errors are logged with the exit code by Execute.
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// The usage is only printed for the invalid arguments and flags, not for the failed commands.
+		cmd.SilenceUsage = true
+		if err := setupLogLevel(); err != nil {
+			return err
+		}
+		setupColor()
+		if err := startProfiling(); err != nil {
+			return err
+		}
+		if operationTimeout > 0 {
+			operationContext, cancelOperation = context.WithTimeout(context.Background(), operationTimeout)
+			go exitOnTimeout(operationContext)
+		}
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		cancelOperation()
+		stopProfiling()
+	},
 }
 
+var (
+	operationTimeout time.Duration
+	// operationContext is shared by all the S3 calls, it's cancelled when the --timeout is exceeded.
+	operationContext = context.Background()
+	cancelOperation  = func() {}
+	// operationProgress describes the completed work of the running command for the timeout message.
+	operationProgress func() string
+	// timeoutGrace is the time for the command to return after the --timeout before it's forced to exit.
+	timeoutGrace = 30 * time.Second
+)
+
+func init() {
+	rootCmd.PersistentFlags().DurationVarP(&operationTimeout, "timeout", "", 0, "The wall-clock limit for the whole operation like 30m, 0 for no limit")
+}
+
+// timeoutError replaces the error of the command stopped by the --timeout, the cancelled S3 calls could fail
+// the command in any way. The command finished before the timeout keeps its error.
+func timeoutError(err error) error {
+	if !errors.Is(operationContext.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if operationProgress != nil {
+		warnf("Completed before the timeout: %s", operationProgress())
+	}
+	return exitErrorf(ExitFailure, "The operation timed out after %v", operationTimeout)
+}
+
+// exitOnTimeout is the fallback for the command which doesn't return after the operation context is cancelled,
+// like the long running libvips conversion. It exits after the grace period and skips the deferred cleanups.
+func exitOnTimeout(ctx context.Context) {
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return
+	}
+	time.Sleep(timeoutGrace)
+	errorf("%v, the command didn't stop in %v", timeoutError(nil), timeoutGrace)
+	stopProfiling()
+	os.Exit(ExitFailure)
+}
+
+// Execute runs the command and exits with the code of the returned ExitError, see the ExitFailure for the codes.
+// It's the only exit of the failed commands, the deferred cleanups of the commands are always executed.
 func Execute() {
-	err := rootCmd.Execute()
-	if err != nil {
-		os.Exit(1)
+	err := timeoutError(rootCmd.Execute())
+	if err == nil {
+		return
 	}
+	// The failed command skips the PersistentPostRun.
+	cancelOperation()
+	stopProfiling()
+	errorf("%v", err)
+	os.Exit(exitCode(err))
 }
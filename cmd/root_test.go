@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutError(t *testing.T) {
+	original, timeout := operationContext, operationTimeout
+	defer func() { operationContext, operationTimeout = original, timeout }()
+
+	failed := exitErrorf(ExitPartial, "failed to sync 2 files")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	operationContext = ctx
+	if err := timeoutError(failed); !errors.Is(err, failed) {
+		t.Errorf("The cancelled command keeps its error, got %v", err)
+	}
+	if err := timeoutError(nil); err != nil {
+		t.Errorf("The finished command succeeds, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	operationContext, operationTimeout = ctx, time.Minute
+	for _, err := range []error{nil, failed} {
+		timedOut := timeoutError(err)
+		if exitCode(timedOut) != ExitFailure || !strings.Contains(timedOut.Error(), "timed out after 1m0s") {
+			t.Errorf("The command stopped by the timeout fails with %v, want the timeout", timedOut)
+		}
+	}
+}
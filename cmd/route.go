@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"path"
+	"strings"
+
+	"github.com/qingstor/go-mime"
+)
+
+// The MIME categories for routing the files by sync.routeByType.
+const (
+	RouteImage    = "image"
+	RouteVideo    = "video"
+	RouteAudio    = "audio"
+	RouteDocument = "document"
+)
+
+// documentTypes are the MIME types of the documents besides the text/*.
+var documentTypes = map[string]struct{}{
+	"application/pdf":                                 {},
+	"application/msword":                              {},
+	"application/rtf":                                 {},
+	"application/epub+zip":                            {},
+	"application/vnd.ms-excel":                        {},
+	"application/vnd.ms-powerpoint":                   {},
+	"application/vnd.oasis.opendocument.text":         {},
+	"application/vnd.oasis.opendocument.spreadsheet":  {},
+	"application/vnd.oasis.opendocument.presentation": {},
+}
+
+func isValidRoute(category string) bool {
+	return category == RouteImage || category == RouteVideo || category == RouteAudio || category == RouteDocument
+}
+
+// mimeCategory detects the MIME category of the file by its extension, it's empty for the other files.
+func mimeCategory(key string) string {
+	ext := strings.TrimPrefix(path.Ext(key), ".")
+	if ext == "" {
+		return ""
+	}
+	contentType := mime.DetectFileExt(strings.ToLower(ext))
+	major, _, _ := strings.Cut(contentType, "/")
+	switch {
+	case major == RouteImage || major == RouteVideo || major == RouteAudio:
+		return major
+	case major == "text" && contentType != "text/html" && contentType != "text/css" && contentType != "text/javascript":
+		return RouteDocument
+	case strings.HasPrefix(contentType, "application/vnd.openxmlformats-officedocument."):
+		return RouteDocument
+	}
+	if _, ok := documentTypes[contentType]; ok {
+		return RouteDocument
+	}
+	return ""
+}
+
+// routeKey replaces the top-level directory of the local key by the remote directory of its MIME category,
+// like uploads/2024/intro.mp4 into videos/2024/intro.mp4. The file in the project root is placed into
+// the remote directory. It returns false if the category isn't routed.
+func (bucket *BucketClient) routeKey(key string) (string, bool) {
+	if len(bucket.Routes) == 0 {
+		return key, false
+	}
+	prefix, ok := bucket.Routes[mimeCategory(key)]
+	if !ok {
+		return key, false
+	}
+	if _, rest, found := strings.Cut(key, "/"); found {
+		key = rest
+	}
+	return strings.TrimPrefix(prefix+"/"+key, "/"), true
+}
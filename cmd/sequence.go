@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// SequenceFileName is the state file in the config directory, which holds the last sequence of every date.
+	SequenceFileName = "sequence.json"
+	sequenceLockWait = 5 * time.Second
+	// sequenceStaleLock is the age of the lock file left by a crashed process.
+	sequenceStaleLock = 30 * time.Second
+)
+
+// nextSequence increments and returns the sequence of the date, like 2 for the second image of 20240101.
+// The state file is guarded by a lock file for the concurrent runs.
+func nextSequence(date time.Time) (int, error) {
+	path := filepath.Join(configPath, SequenceFileName)
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	sequences := map[string]int{}
+	content, err := os.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(content, &sequences); err != nil {
+			return 0, fmt.Errorf("invalid sequence file %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	key := date.Format("20060102")
+	sequences[key]++
+	content, err = json.MarshalIndent(sequences, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err = os.WriteFile(path, content, os.FileMode(0644)); err != nil {
+		return 0, err
+	}
+	return sequences[key], nil
+}
+
+// lockFile creates the lock file exclusively, the stale lock file is removed.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(sequenceLockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(0644))
+		if err == nil {
+			_ = file.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if stat, e := os.Stat(path); e == nil && time.Since(stat.ModTime()) > sequenceStaleLock {
+			_ = os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the lock file %s is held by another process", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
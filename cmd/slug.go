@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// slugifyKey converts every segment of the object key into a lowercase and URL safe slug.
+// The file extension is kept and lower-cased.
+func slugifyKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		ext := ""
+		if i == len(segments)-1 {
+			ext = strings.ToLower(path.Ext(segment))
+			segment = strings.TrimSuffix(segment, path.Ext(segment))
+		}
+		segments[i] = slugify(segment) + ext
+	}
+	return strings.Join(segments, "/")
+}
+
+// slugify transliterates the accented letters into ASCII and replaces the other characters with hyphens.
+// The letters which couldn't be transliterated, like CJK characters, are dropped and a short hash of the
+// original text is appended for keeping the slug unique.
+func slugify(s string) string {
+	var b strings.Builder
+	dropped, hyphen := false, false
+	for _, r := range norm.NFKD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// The diacritic marks are removed.
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(unicode.ToLower(r))
+			hyphen = false
+		default:
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				dropped = true
+			}
+			if !hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+				hyphen = true
+			}
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if dropped || slug == "" {
+		sum := sha256.Sum256([]byte(s))
+		if slug != "" {
+			slug += "-"
+		}
+		slug += hex.EncodeToString(sum[:4])
+	}
+	return slug
+}
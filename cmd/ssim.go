@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// ssimCompareWidth is the max width of the previews compared by SSIM, the larger image costs too much.
+	ssimCompareWidth = 512
+	// ssimWindow is the size of the square windows for computing the local SSIM.
+	ssimWindow = 8
+)
+
+// encodeWithinSSIM binary searches the lowest quality between the min quality and the requested quality,
+// whose output has the SSIM against the lossless reference at least the target. The requested quality
+// is kept if the target couldn't be achieved.
+func encodeWithinSSIM(encode func(quality int) ([]byte, error), reference []byte, quality, minQuality int, target float64) ([]byte, error) {
+	width, height, err := ssimPreviewSize(reference)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := ssimPreview(reference, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	var best []byte
+	bestQuality, bestSSIM := 0, 0.0
+	low, high := minQuality, quality
+	for low <= high {
+		q := (low + high) / 2
+		encoded, err := encode(q)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := ssimPreview(encoded, width, height)
+		if err != nil {
+			return nil, err
+		}
+		if score := ssim(expected, actual); score >= target {
+			best, bestQuality, bestSSIM = encoded, q, score
+			high = q - 1
+		} else {
+			low = q + 1
+		}
+	}
+
+	if best == nil {
+		encoded, err := encode(quality)
+		if err != nil {
+			return nil, err
+		}
+		warnf("The SSIM target %.4f is not achievable, keep the quality %d with %d bytes\n", target, quality, len(encoded))
+		return encoded, nil
+	}
+	debugf("Encode the image with quality %d in %d bytes, the SSIM is %.4f\n", bestQuality, len(best), bestSSIM)
+	return best, nil
+}
+
+// ssimReference processes the lossless reference of the encoded candidates from a copy of the image.
+// The bimg processing replaces the buffer of the image, the candidates should be processed from the source.
+func ssimReference(image *bimg.Image, options bimg.Options) ([]byte, error) {
+	reference := options
+	reference.Type = bimg.PNG
+	reference.Interlace = false
+	return bimg.NewImage(image.Image()).Process(reference)
+}
+
+// ssimPreviewSize scales the image size down to the compare width.
+func ssimPreviewSize(buf []byte) (int, int, error) {
+	size, err := bimg.NewImage(buf).Size()
+	if err != nil {
+		return 0, 0, err
+	}
+	if size.Width <= ssimCompareWidth {
+		return size.Width, size.Height, nil
+	}
+	return ssimCompareWidth, max(1, size.Height*ssimCompareWidth/size.Width), nil
+}
+
+// ssimPreview decodes the image into the luminance of the given size, all the previews are resized in the same way.
+func ssimPreview(buf []byte, width, height int) (*image.Gray, error) {
+	preview, err := bimg.NewImage(buf).Process(bimg.Options{Width: width, Height: height, Force: true, Type: bimg.PNG})
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := png.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := decoded.Bounds()
+	if bounds.Dx() != width || bounds.Dy() != height {
+		return nil, fmt.Errorf("the preview is %dx%d instead of %dx%d", bounds.Dx(), bounds.Dy(), width, height)
+	}
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gray.SetGray(x, y, color.GrayModel.Convert(decoded.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray))
+		}
+	}
+	return gray, nil
+}
+
+// ssim computes the mean of the local SSIM in the non-overlapping windows of the two same size images.
+func ssim(a, b *image.Gray) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	bounds := a.Bounds()
+	total, windows := 0.0, 0
+	for y := 0; y < bounds.Dy(); y += ssimWindow {
+		for x := 0; x < bounds.Dx(); x += ssimWindow {
+			var sumA, sumB, sumAA, sumBB, sumAB, n float64
+			for dy := y; dy < min(y+ssimWindow, bounds.Dy()); dy++ {
+				for dx := x; dx < min(x+ssimWindow, bounds.Dx()); dx++ {
+					va, vb := float64(a.GrayAt(dx, dy).Y), float64(b.GrayAt(dx, dy).Y)
+					sumA, sumB = sumA+va, sumB+vb
+					sumAA, sumBB, sumAB = sumAA+va*va, sumBB+vb*vb, sumAB+va*vb
+					n++
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA, varB := sumAA/n-meanA*meanA, sumBB/n-meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+			total += (2*meanA*meanB + c1) * (2*covariance + c2) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestSSIMReference(t *testing.T) {
+	requireVips(t)
+	source := testJPEG(t, 640, 480, gradient)
+	format := imageFormat
+	defer func() { imageFormat = format }()
+	imageFormat = JPG
+
+	// The brightness applied twice shifts the candidate away from the reference.
+	options := bimg.Options{Width: 320, Height: 240, Brightness: 60, Type: bimg.JPEG}
+	image := bimg.NewImage(source)
+	reference, err := ssimReference(image, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image.Image(), source) {
+		t.Fatal("The reference shouldn't replace the buffer of the image")
+	}
+	candidate, err := newEncoder(image, options)(95)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	width, height, err := ssimPreviewSize(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ssimPreview(reference, width, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ssimPreview(candidate, width, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := ssim(expected, actual); score < 0.97 {
+		t.Errorf("The candidate should be processed once like the reference, the SSIM is %.4f", score)
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultSVGDensity is the density used by libvips for rasterizing SVG images.
+const DefaultSVGDensity = 72
+
+var (
+	svgRootPattern    = regexp.MustCompile(`(?is)<svg\b[^>]*>`)
+	svgWidthPattern   = regexp.MustCompile(`(?is)\swidth\s*=\s*["']\s*([\d.]+)(px)?\s*["']`)
+	svgHeightPattern  = regexp.MustCompile(`(?is)\sheight\s*=\s*["']\s*([\d.]+)(px)?\s*["']`)
+	svgViewBoxPattern = regexp.MustCompile(`(?is)\sviewBox\s*=\s*["']\s*[-\d.]+[\s,]+[-\d.]+[\s,]+([\d.]+)[\s,]+([\d.]+)\s*["']`)
+)
+
+// scaleSVG rewrites the size of the root svg element, so that libvips rasterizes it at a higher resolution.
+// The density is in DPI and 72 means the intrinsic size. If the density is 0, the SVG is scaled up for
+// matching the target width. The original content is returned if the intrinsic size couldn't be detected.
+func scaleSVG(content []byte, density float64, targetWidth int) []byte {
+	root := svgRootPattern.Find(content)
+	if root == nil {
+		return content
+	}
+
+	width, height := svgAttribute(svgWidthPattern, root), svgAttribute(svgHeightPattern, root)
+	viewBox := svgViewBoxPattern.FindSubmatch(root)
+	if (width == 0 || height == 0) && viewBox != nil {
+		width, _ = strconv.ParseFloat(string(viewBox[1]), 64)
+		height, _ = strconv.ParseFloat(string(viewBox[2]), 64)
+	}
+	if width <= 0 || height <= 0 {
+		warnf("Couldn't detect the intrinsic size of the SVG image, rasterize it at the default density")
+		return content
+	}
+
+	scale := density / DefaultSVGDensity
+	if density == 0 {
+		scale = max(1, float64(targetWidth)/width)
+	}
+	if scale == 1 {
+		return content
+	}
+
+	// Replace the size and keep the original coordinate system by the viewBox.
+	tag := svgWidthPattern.ReplaceAllString(string(root), "")
+	tag = svgHeightPattern.ReplaceAllString(tag, "")
+	attributes := fmt.Sprintf(` width="%g" height="%g"`, width*scale, height*scale)
+	if viewBox == nil {
+		attributes += fmt.Sprintf(` viewBox="0 0 %g %g"`, width, height)
+	}
+	tag = tag[:4] + attributes + tag[4:]
+	debugf("Rasterize the SVG image from %gx%g to %gx%g\n", width, height, width*scale, height*scale)
+
+	return []byte(strings.Replace(string(content), string(root), tag, 1))
+}
+
+func svgAttribute(pattern *regexp.Regexp, root []byte) float64 {
+	matches := pattern.FindSubmatch(root)
+	if matches == nil {
+		return 0
+	}
+	value, err := strconv.ParseFloat(string(matches[1]), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
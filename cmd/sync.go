@@ -3,25 +3,40 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	"github.com/aws/smithy-go/middleware"
-	"github.com/aws/smithy-go/transport/http"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/h2non/bimg"
-	"github.com/qingstor/go-mime"
 	"github.com/spf13/cobra"
 )
 
@@ -29,113 +44,437 @@ const (
 	BlurDataFormat    = `data:image/webp;base64,%s`
 	ImageMetadataFile = "images/metadata.json"
 	BlurWidth         = 8
+	// DefaultMultipartThreshold is the object size uploaded in multiple parts, MaxPutObjectSize is the limit of a single upload.
+	DefaultMultipartThreshold = 100 * 1024 * 1024
+	MaxPutObjectSize          = 5 * 1024 * 1024 * 1024
+	// ImmutableCacheControl is the default Cache-Control of the images, NoCacheControl is for the metadata.
+	ImmutableCacheControl = "public, max-age=31536000, immutable"
+	NoCacheControl        = "no-cache"
+	// DefaultSyncConcurrency is the max number of the files uploaded in parallel.
+	DefaultSyncConcurrency = 8
+	// CompareList detects the changed files by the object sizes in the listed directories.
+	CompareList = "list"
+	// CompareHead detects the changed files by the Content-Length and Last-Modified of the HEAD requests.
+	CompareHead = "head"
+	// DigestHex and DigestBase64 are the encodings of the SHA-256 digest in the metadata.
+	DigestHex    = "hex"
+	DigestBase64 = "base64"
+	// MtimeMetadataKey is the user metadata key (x-amz-meta-mtime) for the local file modification time.
+	MtimeMetadataKey = "mtime"
 )
 
 var (
 	syncCmd = &cobra.Command{
 		Use:   "sync",
 		Short: "A tool for syncing files to UPYUN. A metadata file will be generated to track the synced files.",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			// Create S3 client.
-			config := ReadConfig()
-			client := newBucketClient(config)
+			config, err := ReadValidConfig(true)
+			if err != nil {
+				return err
+			}
+			if err := setupLogging(config); err != nil {
+				return err
+			}
+			if err := setupVips(config); err != nil {
+				return err
+			}
+			if syncExpires < 0 {
+				return exitErrorf(ExitFailure, "Invalid expires %v, it should be a positive duration", syncExpires)
+			}
+			client, err := newBucketClient(config)
+			if err != nil {
+				return err
+			}
+			client.NoOverwrite = noOverwrite
+			client.SetExpires(syncExpires)
+			sha256Encoding = config.Sync.SHA256
+			blurFormat = config.Metadata.BlurFormat
+			if syncReport != "" {
+				linkReport = &LinkReport{BaseURL: config.BaseURL}
+				defer func() {
+					if err := linkReport.Write(syncReport); err != nil {
+						errorf("Failed to write the report %s\nError: %v", syncReport, err)
+					}
+				}()
+			}
+			if syncDryRun {
+				client.DryRun = &DryRunReport{}
+				defer client.DryRun.Print(os.Stdout)
+			}
+
+			// Re-upload the existing image metadata with the current settings, no object will be synced.
+			if overwriteMetadataOnly {
+				metas, err := DownloadMetadata(client, config)
+				if err != nil {
+					return exitErrorf(ExitFailure, "Failed to load the existing image metadata.\nError: %v", err)
+				}
+				UploadMetadata(client, config, metas)
+				successf("Successfully re-upload the metadata of %d images", len(metas))
+				return nil
+			}
+
+			// Load the previous image metadata for skipping the decoding of the unchanged images.
+			if reuseMetadata && !recomputeMetadata {
+				previous, err := DownloadMetadata(client, config)
+				if err != nil {
+					warnf("Failed to load the previous image metadata, all the metadata will be regenerated.\nError: %v", err)
+				}
+				previousMetadata = newMetadataIndex(previous)
+			}
+
+			if excludeLargerThan < 0 || excludeSmallerThan < 0 {
+				return exitErrorf(ExitFailure, "The --exclude-larger-than and --exclude-smaller-than should not be negative")
+			}
+			if excludeLargerThan > 0 && excludeSmallerThan > excludeLargerThan {
+				return exitErrorf(ExitFailure, "The --exclude-smaller-than %d is larger than the --exclude-larger-than %d, all the files are skipped", excludeSmallerThan, excludeLargerThan)
+			}
+			if err := loadExcludePatterns(config.ProjectRoot); err != nil {
+				return exitErrorf(ExitFailure, "Failed to load the exclude patterns.\nError: %v", err)
+			}
+			if syncConcurrency < 1 {
+				return exitErrorf(ExitFailure, "Invalid concurrency %d, it should be a positive number", syncConcurrency)
+			}
+			syncTokens = make(chan struct{}, syncConcurrency)
+			partTokens = make(chan struct{}, syncConcurrency)
+			if compareMtime && !preserveMtime {
+				return exitErrorf(ExitFailure, "The --compare-mtime should be used with --preserve-mtime")
+			}
+			// The plan couldn't be reviewed without a terminal, the files are synced directly.
+			if syncInteractive && !syncYes && !syncDryRun && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+				syncPlan = &SyncPlan{}
+			}
+
+			// Only sync the listed files and merge their metadata into the existing one.
+			if filesFrom != "" && sinceCommit != "" {
+				return exitErrorf(ExitFailure, "The --files-from and --since-commit couldn't be used together")
+			}
+			if syncPrune && (filesFrom != "" || sinceCommit != "") {
+				return exitErrorf(ExitFailure, "The --prune couldn't be used with --files-from or --since-commit, all the unlisted files would be pruned")
+			}
+			if syncPrune && (excludeLargerThan > 0 || excludeSmallerThan > 0 || len(excludeExtensions) > 0 || len(excludePatterns) > 0) {
+				return exitErrorf(ExitFailure, "The --prune couldn't be used with the --exclude-* flags, the objects of the excluded files would be pruned")
+			}
+			if filesFrom != "" || sinceCommit != "" {
+				var files, deleted []string
+				if sinceCommit != "" {
+					changed, removed, err := gitChangedFiles(config.ProjectRoot, sinceCommit, []string{"images", "uploads"})
+					if err != nil {
+						return exitErrorf(ExitFailure, "Failed to find the changed files by git.\nError: %v", err)
+					}
+					infof("Found %d changed and %d deleted files since %s", len(changed), len(removed), sinceCommit)
+					for _, file := range changed {
+						files = append(files, filepath.Join(config.ProjectRoot, filepath.FromSlash(file)))
+					}
+					deleted = removed
+				} else {
+					var err error
+					if files, err = readFileList(filesFrom); err != nil {
+						return exitErrorf(ExitFailure, "Failed to read the file list from %s.\nError: %v", filesFrom, err)
+					}
+				}
+				summary := &SyncSummary{}
+				operationProgress = summary.String
+				metas := SyncFiles(client, config, files, summary)
+				if syncPlan != nil && !summary.Aborted() {
+					var ok bool
+					if metas, ok = confirmSyncPlan(client, config, metas, summary); !ok {
+						log.Println("Nothing is synced")
+						return nil
+					}
+				}
+				if summary.Aborted() {
+					return summary.Err()
+				}
+				successf("Successfully sync the listed files: %s", summary)
+
+				existing, err := DownloadMetadata(client, config)
+				if err != nil {
+					return exitErrorf(ExitFailure, "Failed to load the existing image metadata, the metadata isn't updated.\nError: %v", err)
+				}
+				merged := mergeMetadata(existing, metas)
+				if len(deleted) > 0 {
+					merged = PruneDeletedFiles(client, config, merged, deleted)
+				}
+				UploadMetadata(client, config, merged)
+				successf("Successfully upload the image metadata")
+				UploadHeadersFile(client, config)
+				UploadDirectoryIndex(client, config)
+				return summary.Err()
+			}
 
-			// Upload the files into the S3.
+			// Upload the top-level directories into the S3 concurrently.
+			directories := []string{"images", "uploads"}
+			// The unreadable directories fail in SyncDirectory too, the failed sync never prunes the unclaimed keys.
+			files, _ := listSyncFiles(config.ProjectRoot, directories)
+			if err := claimKeys(client, config.ProjectRoot, files); err != nil {
+				return exitErrorf(ExitConfig, "Failed to map the files into the object keys.\nError: %v", err)
+			}
+			results := make([][]ImageMetadata, len(directories))
+			summaries := make([]*SyncSummary, len(directories))
+			for i := range summaries {
+				summaries[i] = &SyncSummary{}
+			}
+			operationProgress = func() string {
+				total := &SyncSummary{}
+				for _, summary := range summaries {
+					total.Add(summary)
+				}
+				return total.String()
+			}
+			var wg sync.WaitGroup
+			for i, directory := range directories {
+				wg.Add(1)
+				go func(i int, directory string) {
+					defer wg.Done()
+					results[i] = SyncDirectory(client, config.ProjectRoot, filepath.Join(config.ProjectRoot, directory), summaries[i])
+					if !summaries[i].Aborted() {
+						successf("Successfully sync the directory %s: %s", directory, summaries[i])
+					}
+				}(i, directory)
+			}
+			wg.Wait()
+
+			// Merge the metadata in the directory order, no matter which directory finishes first.
 			var metas []ImageMetadata
-			for _, directory := range []string{"images", "uploads"} {
-				r := SyncDirectory(client, config.ProjectRoot, filepath.Join(config.ProjectRoot, directory))
-				if r != nil {
-					metas = append(metas, r...)
+			total := &SyncSummary{}
+			for i := range directories {
+				metas = append(metas, results[i]...)
+				total.Add(summaries[i])
+			}
+			if syncPlan != nil && !total.Aborted() {
+				// The orphans are reviewed with the uploads, they are only planned if every file is read.
+				if syncPrune && total.Err() != nil {
+					warnf("Skip pruning the orphaned objects, some files failed to sync")
+				} else if syncPrune {
+					orphans, err := findOrphans(client, config, directories)
+					if err != nil {
+						errorf("Failed to find the orphaned objects, nothing is pruned.\nError: %v", err)
+					}
+					for _, key := range orphans {
+						syncPlan.Add(SyncPlanItem{Key: key, Action: PlanDelete})
+					}
 				}
+				var ok bool
+				if metas, ok = confirmSyncPlan(client, config, metas, total); !ok {
+					log.Println("Nothing is synced")
+					return nil
+				}
+			}
+			// The metadata of the aborted sync misses the unsynced files, it's never uploaded.
+			if total.Aborted() {
+				return total.Err()
 			}
-			log.Println("Successfully sync the directories")
+			successf("Successfully sync the directories: %s", total)
 
 			// Upload the generated image metadata.
-			log.Println("Generate the image metadata")
+			infof("Generate the image metadata")
 			UploadMetadata(client, config, metas)
-			log.Println("Successfully upload the image metadata")
+			successf("Successfully upload the image metadata")
+			UploadHeadersFile(client, config)
+			if syncPrune && syncPlan == nil {
+				if total.Err() != nil {
+					warnf("Skip pruning the orphaned objects, some files failed to sync")
+				} else {
+					PruneOrphans(client, config, directories, syncYes)
+				}
+			}
+			UploadDirectoryIndex(client, config)
+			return total.Err()
 		},
 	}
 
-	forceUpload = false
+	forceUpload           = false
+	excludeExtensions     []string
+	reuseMetadata         = true
+	recomputeMetadata     = false
+	previousMetadata      *metadataIndex
+	overwriteMetadataOnly = false
+	filesFrom             = ""
+	preserveMtime         = false
+	compareMtime          = false
+	excludeLargerThan     int64
+	excludeSmallerThan    int64
+	syncDryRun            = false
+	noOverwrite           = false
+	computePHash          = false
+	syncInteractive       = false
+	syncExpires           time.Duration
+	syncReport            = ""
+	sinceCommit           = ""
+	syncYes               = false
+	prefixFromDate        = false
+	syncPrune             = false
+	syncConcurrency       = DefaultSyncConcurrency
+	// syncTokens bounds the concurrent file uploads and directory listings.
+	syncTokens = make(chan struct{}, DefaultSyncConcurrency)
+	// partTokens bounds the concurrent part uploads of the multipart uploads in the same --concurrency. The parts
+	// never take the syncTokens, the files holding them would wait for their parts forever in a shared pool.
+	partTokens = make(chan struct{}, DefaultSyncConcurrency)
+	// syncPlan collects the uploads for reviewing them before uploading, it's nil if the sync isn't interactive.
+	syncPlan *SyncPlan
+	// sha256Encoding is the sync.sha256 in config, the digest isn't recorded if it's empty.
+	sha256Encoding = ""
+	// claimedKeys maps the object key of every synced file to the file, it's claimed by claimKeys before syncing.
+	claimedKeys = map[string]string{}
 )
 
 func init() {
 	syncCmd.Flags().BoolVarP(&forceUpload, "force", "", false, "Force upload the files to S3")
+	syncCmd.Flags().IntVarP(&syncConcurrency, "concurrency", "", DefaultSyncConcurrency, "The max number of the files uploaded and the directories listed in parallel")
+	syncCmd.Flags().BoolVarP(&syncPrune, "prune", "", false, "Delete the objects in the synced directories without the local files, it's confirmed unless --yes is given")
+	syncCmd.Flags().BoolVarP(&prefixFromDate, "prefix-from-date", "", false, "Upload the images under images/<year>/<month>/ by their EXIF or modification date, no matter where they are placed locally")
+	syncCmd.Flags().StringSliceVarP(&excludeExtensions, "exclude-ext", "", nil, "Skip the files with the given extensions, like psd,ai,tiff")
+	syncCmd.Flags().BoolVarP(&reuseMetadata, "reuse-metadata", "", true, "Reuse the uploaded image metadata for the images with the same content hash")
+	_ = syncCmd.Flags().MarkDeprecated("reuse-metadata", "the uploaded image metadata is reused by default, use --recompute for disabling it")
+	syncCmd.Flags().BoolVarP(&recomputeMetadata, "recompute", "", false, "Decode all the images for regenerating the metadata instead of reusing the uploaded one")
+	syncCmd.Flags().BoolVarP(&overwriteMetadataOnly, "overwrite-metadata-only", "", false, "Re-upload the existing image metadata with the current settings without syncing files")
+	syncCmd.Flags().StringVarP(&filesFrom, "files-from", "", "", "Only sync the files listed in the given file, one path per line, - for reading from stdin")
+	syncCmd.Flags().BoolVarP(&preserveMtime, "preserve-mtime", "", false, "Store the local file modification time in the x-amz-meta-mtime of the uploaded objects")
+	syncCmd.Flags().BoolVarP(&compareMtime, "compare-mtime", "", false, "Re-upload the files with the same size if their modification time differs from x-amz-meta-mtime")
+	syncCmd.Flags().Int64VarP(&excludeLargerThan, "exclude-larger-than", "", 0, "Skip the files larger than the given bytes, 0 for no limit")
+	syncCmd.Flags().Int64VarP(&excludeSmallerThan, "exclude-smaller-than", "", 0, "Skip the files smaller than the given bytes, 0 for no limit")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "", false, "List the objects which would be uploaded or rewritten without changing the bucket")
+	syncCmd.Flags().BoolVarP(&noOverwrite, "no-overwrite", "", false, "Never overwrite the existing objects, the conflicting files are skipped")
+	syncCmd.Flags().BoolVarP(&computePHash, "phash", "", false, "Compute the perceptual hashes of the images into the metadata for the duplicates command")
+	syncCmd.Flags().DurationVarP(&syncExpires, "expires", "", 0, "Set the HTTP Expires header of the uploaded objects to now plus the duration like 720h, it doesn't delete them")
+	syncCmd.Flags().StringVarP(&sinceCommit, "since-commit", "", "", "Only sync the files changed since the git ref and delete the objects of the deleted files")
+	syncCmd.Flags().StringVarP(&syncReport, "report", "", "", "Write the source, object, link and size of every synced image into the JSON or .jsonl file")
+	syncCmd.Flags().BoolVarP(&syncInteractive, "interactive", "i", false, "Review and select the planned uploads and prunes in the terminal UI before syncing them")
+	syncCmd.Flags().BoolVarP(&syncYes, "yes", "y", false, "Sync all the planned files without the interactive review, and prune without the confirmation")
 	rootCmd.AddCommand(syncCmd)
 }
 
-func SyncDirectory(client *BucketClient, root, path string) []ImageMetadata {
+// SyncSummary counts the synced files and the uploaded files with their bytes.
+type SyncSummary struct {
+	Files    atomic.Int64
+	Uploaded atomic.Int64
+	Bytes    atomic.Int64
+	Failed   atomic.Int64
+	// aborted is the first error which fails all the remaining files, like the rejected credentials.
+	aborted atomic.Pointer[ExitError]
+}
+
+// Add merges the counts of another summary.
+func (s *SyncSummary) Add(other *SyncSummary) {
+	s.Files.Add(other.Files.Load())
+	s.Uploaded.Add(other.Uploaded.Load())
+	s.Bytes.Add(other.Bytes.Load())
+	s.Failed.Add(other.Failed.Load())
+	if err := other.aborted.Load(); err != nil {
+		s.Abort(err)
+	}
+}
+
+// Abort stops syncing the remaining files on the error, only the first error is kept.
+func (s *SyncSummary) Abort(err *ExitError) {
+	s.aborted.CompareAndSwap(nil, err)
+}
+
+// Aborted checks the sync is stopped by an error, the metadata shouldn't be uploaded for the partial sync.
+func (s *SyncSummary) Aborted() bool {
+	return s.aborted.Load() != nil
+}
+
+func (s *SyncSummary) String() string {
+	return fmt.Sprintf("%d files, %d uploaded, %d bytes, %d failed", s.Files.Load(), s.Uploaded.Load(), s.Bytes.Load(), s.Failed.Load())
+}
+
+// Err returns the ExitError if the sync is aborted or any upload failed, it's a partial failure unless nothing is uploaded.
+func (s *SyncSummary) Err() error {
+	if err := s.aborted.Load(); err != nil {
+		return err
+	}
+	if s.Failed.Load() == 0 {
+		return nil
+	}
+	code := ExitPartial
+	if s.Uploaded.Load() == 0 {
+		code = ExitFailure
+	}
+	return &ExitError{Code: code, Err: fmt.Errorf("failed to sync %d files", s.Failed.Load())}
+}
+
+func SyncDirectory(client *BucketClient, root, path string, summary *SyncSummary) []ImageMetadata {
 	var metas []ImageMetadata
 	var wg sync.WaitGroup
 
+	if summary.Aborted() {
+		return metas
+	}
 	if stat, err := os.Stat(path); err != nil {
-		log.Printf("Failed to read current directory %v", path)
+		errorf("Failed to read current directory %v", path)
 		return metas
 	} else if stat.IsDir() && !strings.HasPrefix(stat.Name(), ".") {
+		// The directory holds a token only while it's read and listed, it never waits for the subdirectories
+		// with the token. Otherwise, the pool could be saturated by the parents waiting for their children.
+		syncTokens <- struct{}{}
 		// Load the files/directories from the current directory.
 		files, e := os.ReadDir(path)
 		if e != nil {
-			log.Printf("Failed to read directory %v", path)
+			<-syncTokens
+			// The unlisted files aren't claimed, the failure skips pruning their objects.
+			errorf("Failed to read directory %v", path)
+			summary.Failed.Add(1)
 			return metas
 		}
 
-		// Load the path prefix from AWS S3.
-		objs, e := client.ListObjects(context.TODO(), path[len(root)+1:])
-		if e != nil {
-			log.Printf("Failed to read directory from S3: %v\nError: %v", path[len(root):], e)
+		// Load the path prefix from AWS S3, every file is checked by the HEAD request in the head compare mode instead.
+		var objs []types.Object
+		if !client.CompareHead {
+			objs, e = client.ListObjects(operationContext, client.RemoteKey(strings.ReplaceAll(path[len(root)+1:], string(filepath.Separator), "/")))
 		}
-		awsMetas := map[string]int64{}
+		<-syncTokens
+		var redirect *RegionRedirectError
+		if errors.As(e, &redirect) {
+			summary.Abort(&ExitError{Code: ExitConfig, Err: redirect})
+			return metas
+		} else if isAuthError(e) {
+			summary.Abort(exitErrorf(ExitAuth, "The S3 credentials are rejected.\nError: %v", e))
+			return metas
+		} else if e != nil {
+			errorf("Failed to read directory from S3: %v\nError: %v", path[len(root):], e)
+		}
+		awsMetas := map[string]RemoteObject{}
 		for _, obj := range objs {
-			awsMetas[*obj.Key] = *obj.Size
+			awsMetas[*obj.Key] = newRemoteObject(obj)
 		}
 
-		// Range the files in the current directory.
-		resultChan := make(chan []ImageMetadata, len(files))
+		// Range the files in the current directory, the results are appended under the lock
+		// instead of buffering them in a channel as large as the directory.
+		var mu sync.Mutex
+		collect := func(m ...ImageMetadata) {
+			mu.Lock()
+			defer mu.Unlock()
+			metas = append(metas, m...)
+		}
 		for _, file := range files {
 			if strings.HasPrefix(file.Name(), ".") {
 				continue
+			} else if !file.IsDir() && isExcludedFile(file.Name()) {
+				debugf("Skip the excluded file [%v]", filepath.Join(path, file.Name()))
+				continue
+			} else if isExcludedKey(strings.ReplaceAll(filepath.Join(path, file.Name())[len(root)+1:], string(filepath.Separator), "/")) {
+				debugf("Skip the excluded path [%v]", filepath.Join(path, file.Name()))
+				continue
 			} else if file.IsDir() {
 				// Process directories concurrently.
 				wg.Add(1)
 				go func(subDir string) {
 					defer wg.Done()
-					m := SyncDirectory(client, root, filepath.Join(path, subDir))
-					if m != nil {
-						resultChan <- m
-					}
+					collect(SyncDirectory(client, root, filepath.Join(path, subDir), summary)...)
 				}(file.Name())
 			} else {
-				// Process files concurrently.
+				// Process files concurrently in the bounded pool.
 				wg.Add(1)
+				syncTokens <- struct{}{}
 				go func(filename string) {
-					defer wg.Done()
-					info, e1 := file.Info()
-					if e1 != nil {
-						log.Printf("Failed to read the file %v info", filename)
-						return
-					}
-					key := strings.ReplaceAll(filename[len(root)+1:], string(filepath.Separator), "/")
-					content, e2 := os.ReadFile(filename)
-					if e2 != nil {
-						log.Printf("Failed to read the file %v content", filename)
-						return
-					}
-					if ok, _ := isSupportedImage(file.Name()); ok {
-						meta := ReadImageMetadata(filename, filename[len(root):], content)
-						if meta != nil {
-							resultChan <- []ImageMetadata{*meta}
-						}
-					}
-					if info.Size() != awsMetas[key] || forceUpload {
-						log.Printf("Try to upload the file [%v] to the aws s3", filename)
-						e2 = client.UploadObject(context.TODO(), key, content)
-						if e2 != nil {
-							log.Printf("Failed to upload the file %v to s3", filename)
-							return
-						}
-					} else {
-						log.Printf("Skip the existing file [%v] in aws s3", filename)
+					defer func() {
+						<-syncTokens
+						wg.Done()
+					}()
+					if meta := SyncFile(client, root, filename, awsMetas, summary); meta != nil {
+						collect(*meta)
 					}
 				}(filepath.Join(path, file.Name()))
 			}
@@ -143,148 +482,1069 @@ func SyncDirectory(client *BucketClient, root, path string) []ImageMetadata {
 
 		// Wait for all goroutines to finish processing
 		wg.Wait()
-		close(resultChan)
+	}
 
-		// Collect all metadata results from the channel
-		for result := range resultChan {
-			metas = append(metas, result...)
+	return metas
+}
+
+// RemoteObject is the size and ETag of the listed object for detecting the changed files.
+type RemoteObject struct {
+	Size int64
+	ETag string
+}
+
+func newRemoteObject(obj types.Object) RemoteObject {
+	return RemoteObject{Size: aws.ToInt64(obj.Size), ETag: strings.Trim(aws.ToString(obj.ETag), `"`)}
+}
+
+// Changed compares the content against the remote object. The size is compared first for skipping the
+// hashing of the resized files, the MD5 digest is compared against the ETag if they are in the same size.
+// The multipart ETag like <md5>-<parts> isn't the MD5 of the content, the same size is unchanged for it.
+func (remote RemoteObject) Changed(content []byte) bool {
+	if int64(len(content)) != remote.Size {
+		return true
+	}
+	if remote.ETag == "" || strings.Contains(remote.ETag, "-") {
+		return false
+	}
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:]) != remote.ETag
+}
+
+// SyncFile uploads the file if its content differs from the remote object, the remote objects are keyed by
+// the object keys. It returns the image metadata or nil if the file isn't an image.
+func SyncFile(client *BucketClient, root, filename string, remoteObjects map[string]RemoteObject, summary *SyncSummary) *ImageMetadata {
+	if summary.Aborted() {
+		return nil
+	}
+	info, e1 := os.Stat(filename)
+	if e1 != nil {
+		errorf("Failed to read the file %v info", filename)
+		summary.Failed.Add(1)
+		return nil
+	}
+	if excludeLargerThan > 0 && info.Size() > excludeLargerThan {
+		debugf("Skip the file [%v], its size %d bytes is larger than %d bytes", filename, info.Size(), excludeLargerThan)
+		return nil
+	} else if excludeSmallerThan > 0 && info.Size() < excludeSmallerThan {
+		debugf("Skip the file [%v], its size %d bytes is smaller than %d bytes", filename, info.Size(), excludeSmallerThan)
+		return nil
+	}
+	content, e2 := os.ReadFile(filename)
+	if e2 != nil {
+		errorf("Failed to read the file %v content", filename)
+		summary.Failed.Add(1)
+		return nil
+	}
+	localKey, key, dated := syncKey(client, root, filename, content, info.ModTime())
+
+	var meta *ImageMetadata
+	if ok, _ := isSupportedImage(info.Name()); ok {
+		hash := contentHash(content)
+		meta = previousMetadata.Lookup(filename[len(root):], hash, placeholderFormat())
+		if meta == nil {
+			meta = ReadImageMetadata(filename, filename[len(root):], content)
+		}
+		if meta != nil {
+			meta.Key = key
+			meta.Hash = hash
+			meta.SHA256 = encodeDigest(hash, sha256Encoding)
+			meta.Retina = ""
+			if _, err := os.Stat(retinaName(filename)); err == nil {
+				meta.Retina = retinaName(meta.Slug)
+			}
+			if computePHash && meta.PHash == "" {
+				if phash, err := perceptualHash(content); err != nil {
+					errorf("Failed to compute the perceptual hash for %v", filename)
+				} else {
+					meta.PHash = phash
+				}
+			}
+		}
+	}
+	summary.Files.Add(1)
+	var metadata map[string]string
+	mtime := info.ModTime().UTC().Format(time.RFC3339)
+	if preserveMtime {
+		metadata = map[string]string{MtimeMetadataKey: mtime}
+	}
+	remote, exists := remoteObjects[key]
+	changed := !exists || remote.Changed(content)
+	// The routed or dated object isn't placed under the listed directory, it's checked by the HEAD request.
+	if _, routed := client.routeKey(localKey); client.CompareHead || routed || dated {
+		changed, exists = client.ObjectChanged(operationContext, key, info.Size(), info.ModTime())
+	}
+	if changed || forceUpload || compareMtime && !client.HasMetadata(operationContext, key, MtimeMetadataKey, mtime) {
+		if syncPlan != nil {
+			action := PlanUpload
+			if exists {
+				action = PlanOverwrite
+			}
+			syncPlan.Add(SyncPlanItem{File: filename, Slug: filename[len(root):], Key: key, Size: info.Size(), Metadata: metadata, Image: meta, Action: action})
+			return meta
+		}
+		infof("Try to upload the file [%v] to the aws s3", filename)
+		e2 = client.UploadObject(operationContext, key, content, metadata)
+		if errors.Is(e2, ErrObjectExists) {
+			debugf("Skip the file [%v], the object [%v] already exists", filename, key)
+		} else if isAuthError(e2) {
+			summary.Abort(exitErrorf(ExitAuth, "The S3 credentials are rejected.\nError: %v", e2))
+			return nil
+		} else if e2 != nil {
+			errorf("Failed to upload the file %v to s3", filename)
+			summary.Failed.Add(1)
+			linkReport.AddObject(filename, key, meta, e2)
+			return meta
+		} else {
+			summary.Uploaded.Add(1)
+			summary.Bytes.Add(info.Size())
+		}
+	} else {
+		debugf("Skip the existing file [%v] in aws s3", filename)
+	}
+	linkReport.AddObject(filename, key, meta, nil)
+	return meta
+}
+
+// syncKey maps the file under the root into the local key relative to the root and the object key.
+// The image is nested under its date no matter where it's placed locally, it's true for the dated key.
+func syncKey(client *BucketClient, root, filename string, content []byte, mtime time.Time) (string, string, bool) {
+	localKey := strings.ReplaceAll(filename[len(root)+1:], string(filepath.Separator), "/")
+	dated := false
+	if ok, _ := isSupportedImage(filename); ok && prefixFromDate {
+		localKey, dated = datePrefixKey(localKey, content, mtime), true
+	}
+	return localKey, client.RemoteKey(localKey), dated
+}
+
+// listSyncFiles walks the directories under the root and returns the files which would be synced by
+// SyncDirectory, the hidden and excluded files are skipped. The unreadable entries are skipped and the
+// first error is returned with the other files, the missing directories are ignored.
+func listSyncFiles(root string, directories []string) ([]string, error) {
+	var files []string
+	var walkErr error
+	for _, directory := range directories {
+		_ = filepath.WalkDir(filepath.Join(root, directory), func(filename string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if walkErr == nil && !errors.Is(err, fs.ErrNotExist) {
+					walkErr = err
+				}
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") || isExcludedKey(strings.ReplaceAll(filename[len(root)+1:], string(filepath.Separator), "/")) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || isExcludedFile(d.Name()) {
+				return nil
+			}
+			if info, e := d.Info(); e == nil && (excludeLargerThan > 0 && info.Size() > excludeLargerThan || info.Size() < excludeSmallerThan) {
+				return nil
+			}
+			files = append(files, filename)
+			return nil
+		})
+	}
+	return files, walkErr
+}
+
+// claimKeys claims the object keys of the files before syncing them. The files are checked in the path order,
+// the collided keys of the slugified or mapped names are always reported for the same files in every run.
+func claimKeys(client *BucketClient, root string, files []string) error {
+	sorted := slices.Clone(files)
+	sort.Strings(sorted)
+	for _, filename := range sorted {
+		var content []byte
+		var mtime time.Time
+		if ok, _ := isSupportedImage(filename); ok && prefixFromDate {
+			// The unreadable file fails in SyncFile too, the failed sync never prunes the unclaimed keys.
+			info, err := os.Stat(filename)
+			if err != nil {
+				continue
+			}
+			if content, err = os.ReadFile(filename); err != nil {
+				continue
+			}
+			mtime = info.ModTime()
+		}
+		_, key, _ := syncKey(client, root, filename, content, mtime)
+		if other, ok := claimedKeys[key]; ok && other != filename {
+			return fmt.Errorf("the files %s and %s are both synced into the object key %s, rename one of them", other, filename, key)
+		}
+		claimedKeys[key] = filename
+	}
+	return nil
+}
+
+// SyncFiles uploads the given files which should be placed under the project root.
+func SyncFiles(client *BucketClient, config *PandoraConfig, files []string, summary *SyncSummary) []ImageMetadata {
+	root, err := filepath.Abs(config.ProjectRoot)
+	if err != nil {
+		summary.Abort(exitErrorf(ExitConfig, "Invalid project root %s.\nError: %v", config.ProjectRoot, err))
+		return nil
+	}
+
+	// Load the remote objects once for every directory.
+	remoteObjects := map[string]RemoteObject{}
+	listed := map[string]bool{}
+	var targets []string
+	for _, file := range files {
+		rel, ok := projectKey(config, file)
+		if !ok {
+			debugf("Skip the file [%v] outside the project root %v", file, root)
+			continue
+		}
+		filename := filepath.Join(root, filepath.FromSlash(rel))
+		if stat, e := os.Stat(filename); e != nil || stat.IsDir() {
+			debugf("Skip the invalid file [%v]", file)
+			continue
+		} else if isExcludedFile(stat.Name()) || isExcludedKey(rel) {
+			debugf("Skip the excluded file [%v]", filename)
+			continue
+		}
+		if dir := path.Dir(rel); !listed[dir] && dir != "." && !client.CompareHead {
+			listed[dir] = true
+			objs, e := client.ListObjects(operationContext, client.RemoteKey(dir))
+			var redirect *RegionRedirectError
+			if errors.As(e, &redirect) {
+				summary.Abort(&ExitError{Code: ExitConfig, Err: redirect})
+				return nil
+			} else if isAuthError(e) {
+				summary.Abort(exitErrorf(ExitAuth, "The S3 credentials are rejected.\nError: %v", e))
+				return nil
+			} else if e != nil {
+				errorf("Failed to read directory from S3: %v\nError: %v", dir, e)
+			}
+			for _, obj := range objs {
+				remoteObjects[*obj.Key] = newRemoteObject(obj)
+			}
 		}
+		targets = append(targets, filename)
+	}
+	if err := claimKeys(client, root, targets); err != nil {
+		summary.Abort(exitErrorf(ExitConfig, "Failed to map the files into the object keys.\nError: %v", err))
+		return nil
 	}
 
+	var wg sync.WaitGroup
+	resultChan := make(chan ImageMetadata, len(targets))
+	for _, filename := range targets {
+		wg.Add(1)
+		syncTokens <- struct{}{}
+		go func(filename string) {
+			defer func() {
+				<-syncTokens
+				wg.Done()
+			}()
+			if meta := SyncFile(client, root, filename, remoteObjects, summary); meta != nil {
+				resultChan <- *meta
+			}
+		}(filename)
+	}
+	wg.Wait()
+	close(resultChan)
+
+	var metas []ImageMetadata
+	for meta := range resultChan {
+		metas = append(metas, meta)
+	}
 	return metas
 }
 
+// readFileList reads the non-empty lines from the given file, or from stdin for -.
+func readFileList(source string) ([]string, error) {
+	var content []byte
+	var err error
+	if source == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// PruneDeletedFiles deletes the objects of the deleted files relative to the project root and drops their metadata.
+// The file which still exists is kept.
+func PruneDeletedFiles(client *BucketClient, config *PandoraConfig, metas []ImageMetadata, deleted []string) []ImageMetadata {
+	pruned := map[string]bool{}
+	for _, file := range deleted {
+		if fileExists(filepath.Join(config.ProjectRoot, filepath.FromSlash(file))) {
+			continue
+		}
+		key := client.RemoteKey(file)
+		if err := client.DeleteObject(operationContext, key); err != nil {
+			errorf("Failed to delete the object [%v] of the deleted file [%v]\nError: %v", key, file, err)
+			continue
+		}
+		infof("Delete the object [%v] of the deleted file [%v]", key, file)
+		pruned["/"+file] = true
+	}
+
+	kept := make([]ImageMetadata, 0, len(metas))
+	for _, meta := range metas {
+		if !pruned[meta.Slug] {
+			kept = append(kept, meta)
+		}
+	}
+	return kept
+}
+
+// PruneOrphans deletes the objects under the synced directories and the routed prefixes which aren't
+// claimed by the synced files. The generated objects like the image metadata are never deleted.
+// The orphans are confirmed on the terminal unless the yes is given.
+func PruneOrphans(client *BucketClient, config *PandoraConfig, directories []string, yes bool) {
+	orphans, err := findOrphans(client, config, directories)
+	if err != nil {
+		errorf("Failed to find the orphaned objects, nothing is pruned.\nError: %v", err)
+		return
+	}
+	if len(orphans) == 0 {
+		log.Println("No orphaned object is found")
+		return
+	}
+	for _, key := range orphans {
+		infof("Found the orphaned object [%v]", key)
+	}
+	if client.DryRun == nil && !yes && !confirm(fmt.Sprintf("Delete the %d orphaned objects?", len(orphans))) {
+		log.Println("Nothing is pruned")
+		return
+	}
+	deleteOrphans(client, orphans)
+}
+
+// findOrphans lists the sorted keys of the objects under the synced directories and the routed prefixes
+// which aren't claimed by the local files.
+func findOrphans(client *BucketClient, config *PandoraConfig, directories []string) ([]string, error) {
+	prefixes := map[string]struct{}{}
+	for _, directory := range directories {
+		// The directory missing locally would prune all its objects, it's more likely a wrong project root.
+		if !fileExists(filepath.Join(config.ProjectRoot, directory)) {
+			debugf("Skip pruning the directory %s, it doesn't exist locally", directory)
+			continue
+		}
+		prefixes[client.RemoteKey(directory)+"/"] = struct{}{}
+	}
+	for _, prefix := range client.Routes {
+		prefixes[prefix+"/"] = struct{}{}
+	}
+
+	var orphans []string
+	for _, prefix := range sortedKeys(prefixes) {
+		objs, err := client.ListObjects(operationContext, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the objects in %s: %w", prefix, err)
+		}
+		for _, obj := range objs {
+			key := aws.ToString(obj.Key)
+			if _, claimed := claimedKeys[key]; !claimed && !isGeneratedKey(key, config) {
+				orphans = append(orphans, key)
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// deleteOrphans deletes the orphaned objects, the failed ones are logged and kept.
+func deleteOrphans(client *BucketClient, orphans []string) {
+	deleted := 0
+	for _, key := range orphans {
+		if err := client.DeleteObject(operationContext, key); err != nil {
+			errorf("Failed to delete the orphaned object [%v]\nError: %v", key, err)
+			continue
+		}
+		deleted++
+	}
+	successf("Successfully prune %d orphaned objects", deleted)
+}
+
+// mergeMetadata replaces the existing metadata with the same slug and appends the new ones.
+func mergeMetadata(existing, updated []ImageMetadata) []ImageMetadata {
+	bySlug := make(map[string]ImageMetadata, len(updated))
+	for _, meta := range updated {
+		bySlug[meta.Slug] = meta
+	}
+
+	merged := make([]ImageMetadata, 0, len(existing)+len(updated))
+	for _, meta := range existing {
+		if m, ok := bySlug[meta.Slug]; ok {
+			meta = m
+			delete(bySlug, meta.Slug)
+		}
+		merged = append(merged, meta)
+	}
+	for _, meta := range updated {
+		if _, ok := bySlug[meta.Slug]; ok {
+			merged = append(merged, meta)
+		}
+	}
+	return merged
+}
+
+// isExcludedFile checks the file name against the exclude filters. The exclusion always wins over inclusion.
+func isExcludedFile(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	for _, excluded := range excludeExtensions {
+		if ext == strings.ToLower(strings.TrimPrefix(strings.TrimSpace(excluded), ".")) {
+			return true
+		}
+	}
+	return false
+}
+
 func ReadImageMetadata(file, key string, content []byte) *ImageMetadata {
 	if ok, _ := isSupportedImage(file); ok {
 		image := bimg.NewImage(content)
 		size, err := image.Size()
 		if err != nil {
-			log.Printf("Failed to read the image size for %v", file)
+			errorf("Failed to read the image size for %v", file)
 			return nil
 		}
-		options := bimg.Options{
-			Width:   BlurWidth,
-			Height:  size.Height * BlurWidth / size.Width,
-			Crop:    false,
-			Quality: 1,
-			Rotate:  0,
-			Type:    bimg.WEBP,
-		}
-		b, err := image.Process(options)
+		placeholder, err := placeholderGenerator().Generate(content, size)
 		if err != nil {
-			log.Printf("Failed to generate the blur image %v", err)
+			errorf("Failed to generate the blur image %v", err)
 			return nil
 		}
-		blur := base64.StdEncoding.EncodeToString(b)
 		return &ImageMetadata{
 			Slug:        key,
 			Width:       size.Width,
 			Height:      size.Height,
-			BlurDataURL: fmt.Sprintf(BlurDataFormat, blur),
+			Placeholder: placeholder,
+			BlurFormat:  placeholderFormat(),
 		}
 	}
 	return nil
 }
 
 type ImageMetadata struct {
-	Slug        string `json:"slug"`
-	Width       int    `json:"width"`
-	Height      int    `json:"height"`
-	BlurDataURL string `json:"blurDataURL"`
+	Slug string `json:"slug"`
+	// The object key of the image, it differs from the slug if it's mapped by the sync.prefixMap,
+	// the sync.routeByType or the --prefix-from-date.
+	Key    string `json:"key,omitempty"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// The placeholder fields chosen by the metadata.blurFormat.
+	Placeholder
+	// The normalized metadata.blurFormat of the placeholder fields, the placeholder is regenerated if it's changed.
+	BlurFormat string `json:"blurFormat,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+	// The slug of the @2x image for the high DPI screens, it's empty if there is no such image.
+	Retina string `json:"retina,omitempty"`
+	// The perceptual hash (dHash) in hex format for finding the near-duplicate images.
+	PHash string `json:"phash,omitempty"`
+	// The SHA-256 digest of the uploaded bytes in the sync.sha256 encoding for the integrity checks.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// objectKey returns the uploaded object key, the metadata generated by the old version is mapped from the slug.
+func (meta *ImageMetadata) objectKey(client *BucketClient) string {
+	if meta.Key != "" {
+		return meta.Key
+	}
+	return client.RemoteKey(strings.TrimPrefix(meta.Slug, "/"))
+}
+
+// metadataIndex holds the previous image metadata, the unchanged or renamed images could reuse them.
+type metadataIndex struct {
+	bySlug map[string]ImageMetadata
+	byHash map[string]ImageMetadata
+}
+
+func newMetadataIndex(metas []ImageMetadata) *metadataIndex {
+	index := &metadataIndex{
+		bySlug: make(map[string]ImageMetadata, len(metas)),
+		byHash: make(map[string]ImageMetadata, len(metas)),
+	}
+	for _, meta := range metas {
+		if meta.Hash == "" {
+			// The metadata generated by the old version couldn't be verified.
+			continue
+		}
+		index.bySlug[meta.Slug] = meta
+		index.byHash[meta.Hash] = meta
+	}
+	return index
+}
+
+// Lookup finds the previous metadata by the slug first and the content hash second.
+// The renamed image reuses the metadata with the new slug. It returns nil if nothing could be reused,
+// the metadata with the placeholder in another metadata.blurFormat isn't reused.
+func (index *metadataIndex) Lookup(slug, hash, format string) *ImageMetadata {
+	if index == nil {
+		return nil
+	}
+	if meta, ok := index.bySlug[slug]; ok && meta.Hash == hash && meta.BlurFormat == format {
+		return &meta
+	}
+	if meta, ok := index.byHash[hash]; ok && meta.BlurFormat == format {
+		debugf("Reuse the image metadata of [%v] for the renamed image [%v]", meta.Slug, slug)
+		meta.Slug = slug
+		return &meta
+	}
+	return nil
+}
+
+// encodeDigest converts the hex encoded digest into the given encoding, it's empty for no encoding.
+func encodeDigest(digest, encoding string) string {
+	switch encoding {
+	case DigestHex:
+		return digest
+	case DigestBase64:
+		sum, err := hex.DecodeString(digest)
+		if err != nil {
+			return ""
+		}
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	return ""
+}
+
+// contentHash returns the hex encoded SHA-256 digest of the file content.
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
 }
 
 func UploadMetadata(bucket *BucketClient, config *PandoraConfig, metadata []ImageMetadata) {
+	if config.Sync.MetadataShard == "" || config.Sync.MetadataShard == ShardNone {
+		uploadMetadataFile(bucket, config, ImageMetadataFile, metadata)
+		return
+	}
+
+	// Upload the shards and the index which points to them.
+	shards := ShardMetadata(metadata, config.Sync.MetadataShard)
+	index := make([]MetadataShard, 0, len(shards))
+	for _, name := range sortedKeys(shards) {
+		shard := MetadataShard{Name: name, File: path.Join(ImageMetadataDir, name+".json"), Count: len(shards[name])}
+		uploadMetadataFile(bucket, config, shard.File, shards[name])
+		index = append(index, shard)
+	}
+	uploadMetadataFile(bucket, config, ImageMetadataIndex, index)
+}
+
+// uploadMetadataFile uploads the value as an indented JSON file.
+func uploadMetadataFile(bucket *BucketClient, config *PandoraConfig, key string, value any) {
 	var out strings.Builder
 	enc := json.NewEncoder(&out)
 	enc.SetIndent("", "  ")
-	err := enc.Encode(&metadata)
+	err := enc.Encode(value)
 	if err != nil {
-		log.Fatalf("Failed to generate the JSON file for image metadatas.")
+		errorf("Failed to generate the JSON file %s.\nError: %v", key, err)
+		return
 	}
 	bs := []byte(out.String())
+	if bucket.DryRun != nil {
+		bucket.DryRun.Add("rewrite", key, int64(len(bs)))
+		return
+	}
 
 	// Upload the metadata JSON
-	ctx := context.TODO()
+	ctx := operationContext
 	_, err = bucket.Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:        aws.String(config.S3.Bucket),
-		Key:           aws.String(ImageMetadataFile),
+		Key:           aws.String(key),
 		Body:          bytes.NewReader(bs),
 		ContentLength: aws.Int64(int64(len(bs))),
-		ContentType:   aws.String("application/json"),
+		ContentType:   aws.String(contentType(key)),
+		CacheControl:  aws.String(bucket.cacheControl(key)),
 	})
 	if err != nil {
-		log.Printf("Couldn't upload image meta file. Here's why: %v\n", err)
+		errorf("Couldn't upload image meta file %s. Here's why: %v\n", key, err)
 	} else {
 		err = s3.NewObjectExistsWaiter(bucket.Client).Wait(
-			ctx, &s3.HeadObjectInput{Bucket: aws.String(config.S3.Bucket), Key: aws.String(ImageMetadataFile)}, time.Minute)
+			ctx, &s3.HeadObjectInput{Bucket: aws.String(config.S3.Bucket), Key: aws.String(key)}, time.Minute)
 		if err != nil {
-			log.Printf("Failed attempt to wait for image meta file %s to exist.\n", ImageMetadataFile)
+			errorf("Failed attempt to wait for image meta file %s to exist.\n", key)
 		}
+		bucket.mirror(ctx, key, bs, nil)
 	}
 }
 
-func newBucketClient(config *PandoraConfig) *BucketClient {
-	var client *s3.Client
-	if config.S3.Endpoint == "" {
-		client = s3.NewFromConfig(aws.Config{
-			Region:      config.S3.Region,
-			Credentials: config,
-		}, func(o *s3.Options) {
-			o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
-				return http.AddContentChecksumMiddleware(stack)
-			})
-		})
-	} else {
-		client = s3.NewFromConfig(aws.Config{
-			Region:      "auto",
-			Credentials: config,
-		}, func(o *s3.Options) {
-			o.BaseEndpoint = aws.String(config.S3.Endpoint)
-			o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
-				return http.AddContentChecksumMiddleware(stack)
-			})
+func newBucketClient(config *PandoraConfig) (*BucketClient, error) {
+	bucket, err := newS3BucketClient("s3", &config.S3)
+	if err != nil {
+		return nil, err
+	}
+	bucket.Prefixes = make(map[string]string, len(config.Sync.PrefixMap))
+	for local, remote := range config.Sync.PrefixMap {
+		bucket.Prefixes[strings.Trim(local, "/")] = strings.Trim(remote, "/")
+	}
+	bucket.Slugify = config.Sync.Slugify
+	bucket.Routes = make(map[string]string, len(config.Sync.RouteByType))
+	for category, remote := range config.Sync.RouteByType {
+		bucket.Routes[category] = strings.Trim(remote, "/")
+	}
+	bucket.CompareHead = config.Sync.CompareMode == CompareHead
+	bucket.CacheControl = config.Sync.CacheControl
+	if config.Sync.Mirror != nil {
+		if bucket.Mirror, err = newS3BucketClient("sync.mirror", config.Sync.Mirror); err != nil {
+			return nil, err
+		}
+		bucket.Mirror.CacheControl = config.Sync.CacheControl
+	}
+	return bucket, nil
+}
+
+// newS3BucketClient creates the client for the S3 config, the name is the config path in the error messages.
+func newS3BucketClient(name string, config *S3Config) (*BucketClient, error) {
+	if _, err := config.Retrieve(operationContext); err != nil {
+		return nil, exitErrorf(ExitAuth, "Invalid S3 credentials, set %s.accessKey and %s.accessSecretKey in config file, "+
+			"or the AWS credentials by the AWS_ACCESS_KEY_ID env or the %s.profile.\nError: %v", name, name, name, err)
+	}
+	if config.MultipartPartSize != 0 && config.MultipartPartSize < manager.MinUploadPartSize {
+		return nil, exitErrorf(ExitConfig, "Invalid %s.multipartPartSize %d, it should be at least %d bytes (5MB)", name, config.MultipartPartSize, manager.MinUploadPartSize)
+	}
+	threshold := config.MultipartThreshold
+	if threshold == 0 {
+		threshold = DefaultMultipartThreshold
+	}
+	if threshold < 0 || threshold > MaxPutObjectSize {
+		return nil, exitErrorf(ExitConfig, "Invalid %s.multipartThreshold %d, it should be at most %d bytes (5GB)", name, config.MultipartThreshold, MaxPutObjectSize)
+	}
+	if proxy, err := url.Parse(config.Proxy); config.Proxy != "" && (err != nil || proxy.Host == "") {
+		return nil, exitErrorf(ExitConfig, "Invalid %s.proxy %s, it should be a URL like http://127.0.0.1:7890", name, config.Proxy)
+	}
+
+	client := newS3Client(name, config, config.Endpoint != "" && detectPathStyle(name, config))
+	uploader := manager.NewUploader(partLimitedClient{client}, func(u *manager.Uploader) {
+		if config.MultipartPartSize != 0 {
+			u.PartSize = config.MultipartPartSize
+		}
+		// A single large object could upload all its parts in parallel, the parts of all the objects
+		// are bounded by the partTokens together.
+		u.Concurrency = max(syncConcurrency, 1)
+		// Abort the multipart upload on any part failure, the incomplete uploads are charged for the storage.
+		u.LeavePartsOnError = false
+	})
+	return &BucketClient{Client: client, Uploader: uploader, Bucket: config.Bucket, MultipartThreshold: threshold}, nil
+}
+
+// partLimitedClient bounds the concurrent part uploads of all the multipart uploads by the partTokens.
+type partLimitedClient struct {
+	manager.UploadAPIClient
+}
+
+func (c partLimitedClient) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	partTokens <- struct{}{}
+	defer func() { <-partTokens }()
+	return c.UploadAPIClient.UploadPart(ctx, params, optFns...)
+}
+
+// newS3Client creates the S3 client for the AWS region, or the custom endpoint in the given addressing style.
+func newS3Client(name string, config *S3Config, pathStyle bool, optFns ...func(*s3.Options)) *s3.Client {
+	region := config.Region
+	if config.Endpoint != "" {
+		region = "auto"
+	}
+	return s3.NewFromConfig(aws.Config{
+		Region:      region,
+		Credentials: config,
+		HTTPClient:  newHTTPClient(name, config),
+	}, append([]func(*s3.Options){func(o *s3.Options) {
+		if config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.Endpoint)
+			o.UsePathStyle = pathStyle
+		}
+		if len(config.RetryableErrors) > 0 {
+			o.Retryer = newRetryer(config.RetryableErrors)
+		}
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return smithyhttp.AddContentChecksumMiddleware(stack)
 		})
+	}}, optFns...)...)
+}
+
+// newRetryer creates the standard retryer with the custom error codes, which are checked before the
+// standard retryable set. The true code is always retried and the false code is never retried.
+func newRetryer(codes map[string]bool) aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.Retryables = append([]retry.IsErrorRetryable{retry.IsErrorRetryableFunc(func(err error) aws.Ternary {
+			var apiErr smithy.APIError
+			if !errors.As(err, &apiErr) {
+				return aws.UnknownTernary
+			}
+			retryable, ok := codes[apiErr.ErrorCode()]
+			if !ok {
+				return aws.UnknownTernary
+			}
+			return aws.BoolTernary(retryable)
+		})}, o.Retryables...)
+	})
+}
+
+var (
+	// pathStyles caches the detected addressing style by the endpoint and bucket, the image command
+	// creates a client for every converted image.
+	pathStyles     = map[string]bool{}
+	pathStylesLock sync.Mutex
+)
+
+// detectPathStyle checks the custom endpoint requires the path-style requests, like MinIO or Ceph without
+// the wildcard DNS. The usePathStyle in config skips the detection, the detected style is kept for the run.
+func detectPathStyle(name string, config *S3Config) bool {
+	if config.UsePathStyle != nil {
+		return *config.UsePathStyle
+	}
+	pathStylesLock.Lock()
+	defer pathStylesLock.Unlock()
+
+	key := config.Endpoint + "/" + config.Bucket
+	if pathStyle, ok := pathStyles[key]; ok {
+		return pathStyle
 	}
-	return &BucketClient{Client: client, Bucket: config.S3.Bucket}
+	pathStyle := probePathStyle(name, config)
+	pathStyles[key] = pathStyle
+	return pathStyle
+}
+
+// probePathStyle tries the virtual-hosted style first, the path-style is used if the bucket host
+// couldn't be resolved or connected.
+func probePathStyle(name string, config *S3Config) bool {
+	probe := func(pathStyle bool) error {
+		client := newS3Client(name, config, pathStyle, func(o *s3.Options) { o.RetryMaxAttempts = 1 })
+		_, err := client.HeadBucket(operationContext, &s3.HeadBucketInput{Bucket: aws.String(config.Bucket)})
+		return err
+	}
+	if err := probe(false); err == nil || !isHostError(err) {
+		debugf("Use the virtual-hosted style requests for the %s endpoint", name)
+		return false
+	}
+	if err := probe(true); err == nil || !isHostError(err) {
+		infof("Use the path-style requests for the %s endpoint, set %s.usePathStyle in config file for skipping the detection", name, name)
+		return true
+	}
+	warnf("The %s endpoint is unreachable in both the virtual-hosted and path-style requests", name)
+	return false
+}
+
+// isHostError checks the request failed before reaching the endpoint, like the DNS or TLS host failures.
+func isHostError(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var hostErr x509.HostnameError
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr) || errors.As(err, &hostErr)
+}
+
+// newHTTPClient creates the HTTP client for the S3 calls. The default transport respects the
+// HTTPS_PROXY and NO_PROXY environment variables, the s3.proxy in config overrides them.
+// The invalid proxy is rejected by the config validation, it falls back to the environment variables here.
+func newHTTPClient(name string, config *S3Config) *awshttp.BuildableClient {
+	client := awshttp.NewBuildableClient()
+	if config.Proxy == "" {
+		return client
+	}
+
+	proxy, err := url.Parse(config.Proxy)
+	if err != nil || proxy.Host == "" {
+		warnf("Invalid %s.proxy %s, use the HTTPS_PROXY environment variable instead", name, config.Proxy)
+		return client
+	}
+	return client.WithTransportOptions(func(transport *http.Transport) {
+		transport.Proxy = http.ProxyURL(proxy)
+	})
 }
 
 // BucketClient encapsulates the Amazon Simple Storage Service (Amazon S3) actions
 // used in the sync command.
 // It contains client, an Amazon S3 service client that is used to perform bucket
-// and object actions.
+// and object actions, and uploader, which splits large objects into multipart uploads.
+// The prefixes map the local directories relative to the project root into the remote key prefixes.
 type BucketClient struct {
-	Client *s3.Client
-	Bucket string
+	Client   *s3.Client
+	Uploader *manager.Uploader
+	Bucket   string
+	Prefixes map[string]string
+	Slugify  bool
+	// Routes maps the MIME categories into the remote top-level directories, they take precedence over the prefixes.
+	Routes map[string]string
+	// CompareHead detects the changed files by the HEAD request of every object instead of listing the objects.
+	CompareHead bool
+	// DryRun records the writes instead of sending them to the bucket if it's not nil.
+	DryRun *DryRunReport
+	// NoOverwrite uploads the objects only if they don't exist in the bucket.
+	NoOverwrite bool
+	// Expires sets the HTTP Expires header of the uploaded objects to the upload time plus it, 0 for no header.
+	// It's only a caching hint, the objects are never deleted by it.
+	Expires time.Duration
+	// MultipartThreshold is the object size in bytes from which the multipart upload is used.
+	MultipartThreshold int64
+	// CacheControl is the sync.cacheControl for the uploaded objects, the images are immutable if it's empty.
+	CacheControl string
+	// Mirror is the backup bucket which receives a copy of every uploaded object if it's not nil.
+	Mirror *BucketClient
+	// conditionalUnsupported means the endpoint rejects the If-None-Match, the existence is checked instead.
+	conditionalUnsupported atomic.Bool
 }
 
-// UploadObject reads from a file and puts the data into an object in a bucket.
-func (bucket *BucketClient) UploadObject(ctx context.Context, objectKey string, content []byte) error {
-	_, err := bucket.Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(bucket.Bucket),
-		Key:           aws.String(objectKey),
-		Body:          bytes.NewReader(content),
-		ContentType:   aws.String(mime.DetectFileExt(objectKey[strings.LastIndex(objectKey, ".")+1:])),
-		ContentLength: aws.Int64(int64(len(content))),
-	})
+// ErrObjectExists is returned by UploadObject if the object exists and the NoOverwrite is enabled.
+var ErrObjectExists = errors.New("the object already exists")
+
+// RemoteKey maps the local key relative to the project root into the object key.
+// The routed file is placed by its MIME category, otherwise the longest matched local directory
+// in the prefixes is replaced by its remote prefix. The key is slugified if it's enabled.
+func (bucket *BucketClient) RemoteKey(key string) string {
+	if routed, ok := bucket.routeKey(key); ok {
+		if bucket.Slugify {
+			routed = slugifyKey(routed)
+		}
+		return routed
+	}
+	matched := ""
+	for local := range bucket.Prefixes {
+		if (key == local || strings.HasPrefix(key, local+"/")) && len(local) > len(matched) {
+			matched = local
+		}
+	}
+	if matched != "" {
+		key = strings.TrimPrefix(bucket.Prefixes[matched]+key[len(matched):], "/")
+	}
+	if bucket.Slugify {
+		key = slugifyKey(key)
+	}
+	return key
+}
+
+// UploadObject reads from a file and puts the data into an object in a bucket with the optional user metadata.
+// Objects larger than the configured part size are uploaded in multiple parts.
+// The existing object is kept if the NoOverwrite is enabled, the ErrObjectExists is returned for it.
+func (bucket *BucketClient) UploadObject(ctx context.Context, objectKey string, content []byte, metadata map[string]string) error {
+	if bucket.DryRun != nil {
+		bucket.DryRun.Add("upload", objectKey, int64(len(content)))
+		return nil
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket.Bucket),
+		Key:         aws.String(objectKey),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String(contentType(objectKey)),
+		Metadata:    metadata,
+	}
+	if cacheControl := bucket.cacheControl(objectKey); cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	if bucket.Expires > 0 {
+		input.Expires = aws.Time(time.Now().Add(bucket.Expires))
+	}
+	if bucket.NoOverwrite {
+		if !bucket.conditionalUnsupported.Load() {
+			input.IfNoneMatch = aws.String("*")
+		} else if bucket.ObjectExists(ctx, objectKey) {
+			return ErrObjectExists
+		}
+	}
+	// The large object is uploaded in multiple parts, the part size is grown for fitting the 10000 parts limit.
+	var err error
+	if int64(len(content)) < bucket.MultipartThreshold {
+		_, err = bucket.Client.PutObject(ctx, input)
+	} else {
+		_, err = bucket.Uploader.Upload(ctx, input)
+	}
+	if err != nil && input.IfNoneMatch != nil {
+		var apiErr smithy.APIError
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+			return ErrObjectExists
+		} else if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" ||
+			errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotImplemented {
+			warnf("The endpoint doesn't support the conditional writes, fall back to checking the existing objects")
+			bucket.conditionalUnsupported.Store(true)
+			return bucket.UploadObject(ctx, objectKey, content, metadata)
+		}
+	}
 	if err != nil {
+		err = bucket.explainRegionRedirect(ctx, err)
 		var apiErr smithy.APIError
-		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "EntityTooLarge" {
-			log.Printf("Error while uploading object to %s. The object is too large.\n"+
-				"To upload objects larger than 5GB, use the S3 console (160GB max)\n"+
-				"or the multipart upload API (5TB max).", bucket.Bucket)
+		var redirect *RegionRedirectError
+		if errors.As(err, &redirect) {
+			errorf("%v", redirect)
+		} else if errors.As(err, &apiErr) && apiErr.ErrorCode() == "EntityTooSmall" {
+			errorf("Error while uploading object to %s. The part size is too small for this endpoint.\n"+
+				"Increase the s3.multipartPartSize in the config file.", bucket.Bucket)
+		} else if errors.As(err, &apiErr) && apiErr.ErrorCode() == "EntityTooLarge" {
+			errorf("Error while uploading object to %s. The object is too large for a single upload on this endpoint.\n"+
+				"Decrease the s3.multipartThreshold in the config file.", bucket.Bucket)
 		} else {
-			log.Printf("Couldn't upload file to %v:%v. Here's why: %v\n", bucket.Bucket, objectKey, err)
+			errorf("Couldn't upload file to %v:%v. Here's why: %v\n", bucket.Bucket, objectKey, err)
 		}
 	} else {
 		err = s3.NewObjectExistsWaiter(bucket.Client).
 			Wait(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket.Bucket), Key: aws.String(objectKey)}, time.Minute)
 		if err != nil {
-			log.Printf("Failed attempt to wait for object %s to exist.\n", objectKey)
+			errorf("Failed attempt to wait for object %s to exist.\n", objectKey)
+		}
+	}
+	if err == nil {
+		bucket.mirror(ctx, objectKey, content, metadata)
+	}
+	return err
+}
+
+// SetExpires sets the Expires of the bucket and its mirror, the negative duration is rejected by the commands.
+func (bucket *BucketClient) SetExpires(expires time.Duration) {
+	bucket.Expires = expires
+	if bucket.Mirror != nil {
+		bucket.Mirror.Expires = expires
+	}
+}
+
+// cacheControl returns the Cache-Control of the object. The metadata and the directory indexes are always
+// revalidated for getting the latest one, the images with the timestamp names never change and are cached
+// for a year by default.
+func (bucket *BucketClient) cacheControl(objectKey string) string {
+	if objectKey == ImageMetadataFile || strings.HasPrefix(objectKey, ImageMetadataDir+"/") ||
+		path.Base(objectKey) == "index."+IndexJSON || path.Base(objectKey) == "index."+IndexHTML {
+		return NoCacheControl
+	}
+	if bucket.CacheControl != "" {
+		return bucket.CacheControl
+	}
+	if ok, _ := isSupportedImage(objectKey); ok {
+		return ImmutableCacheControl
+	}
+	return ""
+}
+
+// mirror copies the uploaded object into the mirror bucket, the failures are only warned.
+func (bucket *BucketClient) mirror(ctx context.Context, objectKey string, content []byte, metadata map[string]string) {
+	if bucket.Mirror == nil {
+		return
+	}
+	if err := bucket.Mirror.UploadObject(ctx, objectKey, content, metadata); err != nil {
+		warnf("Failed to mirror the object %s into the bucket %s", objectKey, bucket.Mirror.Bucket)
+	}
+}
+
+// RegionRedirectError means the bucket is placed in another region than the s3.region in config.
+type RegionRedirectError struct {
+	Bucket string
+	Region string
+	Err    error
+}
+
+func (e *RegionRedirectError) Error() string {
+	return fmt.Sprintf("The bucket %s is in region %s, set s3.region accordingly in the config file", e.Bucket, e.Region)
+}
+
+func (e *RegionRedirectError) Unwrap() error {
+	return e.Err
+}
+
+// explainRegionRedirect converts the 301 PermanentRedirect error into a RegionRedirectError with the real
+// bucket region. The region is read from the x-amz-bucket-region response header, or queried by HeadBucket.
+func (bucket *BucketClient) explainRegionRedirect(ctx context.Context, err error) error {
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &respErr) || respErr.HTTPStatusCode() != http.StatusMovedPermanently {
+		return err
+	}
+
+	region := respErr.Response.Header.Get("x-amz-bucket-region")
+	if region == "" {
+		r, e := manager.GetBucketRegion(ctx, bucket.Client, bucket.Bucket)
+		if e != nil {
+			return err
 		}
+		region = r
+	}
+	return &RegionRedirectError{Bucket: bucket.Bucket, Region: region, Err: err}
+}
+
+// ObjectExists checks the object is in the bucket, the object is treated as missing if it couldn't be checked.
+func (bucket *BucketClient) ObjectExists(ctx context.Context, objectKey string) bool {
+	_, err := bucket.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	return err == nil
+}
+
+// HasMetadata checks the user metadata of an object has the given value. It's false if the object is missing.
+func (bucket *BucketClient) HasMetadata(ctx context.Context, objectKey, name, value string) bool {
+	output, err := bucket.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return false
 	}
+	return output.Metadata[name] == value
+}
+
+// ObjectChanged compares the local file with the Content-Length and Last-Modified of the object.
+// The file is changed if the sizes differ or the file is modified after the object. It returns
+// whether the object exists, the missing or unreadable object is treated as changed.
+func (bucket *BucketClient) ObjectChanged(ctx context.Context, objectKey string, size int64, mtime time.Time) (bool, bool) {
+	output, err := bucket.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if !errors.As(err, &notFound) {
+			warnf("Failed to check the object [%v], it will be uploaded\nError: %v", objectKey, err)
+		}
+		return true, false
+	}
+	if aws.ToInt64(output.ContentLength) != size {
+		return true, true
+	}
+	// The Last-Modified has only the second precision.
+	return mtime.Truncate(time.Second).After(aws.ToTime(output.LastModified)), true
+}
+
+// DeleteObject deletes the object in the bucket, the mirror bucket keeps its copy as the backup.
+func (bucket *BucketClient) DeleteObject(ctx context.Context, objectKey string) error {
+	if bucket.DryRun != nil {
+		bucket.DryRun.Add("delete", objectKey, 0)
+		return nil
+	}
+	_, err := bucket.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket.Bucket),
+		Key:    aws.String(objectKey),
+	})
 	return err
 }
 
+// DownloadObject reads the whole content of an object in a bucket.
+func (bucket *BucketClient) DownloadObject(ctx context.Context, objectKey string) ([]byte, error) {
+	output, err := bucket.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = output.Body.Close() }()
+
+	return io.ReadAll(output.Body)
+}
+
+// DownloadObjectRange reads the first bytes of an object in a bucket, like the image header.
+func (bucket *BucketClient) DownloadObjectRange(ctx context.Context, objectKey string, length int64) ([]byte, error) {
+	output, err := bucket.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket.Bucket),
+		Key:    aws.String(objectKey),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", length-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = output.Body.Close() }()
+
+	return io.ReadAll(output.Body)
+}
+
 // ListObjects lists the objects in a bucket.
 func (bucket *BucketClient) ListObjects(ctx context.Context, objectKey string) ([]types.Object, error) {
 	var err error
@@ -300,8 +1560,10 @@ func (bucket *BucketClient) ListObjects(ctx context.Context, objectKey string) (
 		if err != nil {
 			var noBucket *types.NoSuchBucket
 			if errors.As(err, &noBucket) {
-				log.Printf("Bucket %s does not exist.\n", bucket.Bucket)
+				errorf("Bucket %s does not exist.\n", bucket.Bucket)
 				err = noBucket
+			} else {
+				err = bucket.explainRegionRedirect(ctx, err)
 			}
 			break
 		} else {
@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// testS3Config creates the config of the custom endpoint in the path-style requests with the static credentials.
+func testS3Config(endpoint string) *S3Config {
+	return &S3Config{
+		Endpoint:        endpoint,
+		Bucket:          "pandora",
+		AccessKey:       "access",
+		AccessSecretKey: "secret",
+		UsePathStyle:    aws.Bool(true),
+	}
+}
+
+func TestProxy(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requests <- r:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	// The endpoint is never resolved, the request only reaches it through the proxy.
+	config := testS3Config("http://s3.pandora.invalid")
+	config.Proxy = proxy.URL
+	client := newS3Client("s3", config, true)
+	if _, err := client.HeadBucket(t.Context(), &s3.HeadBucketInput{Bucket: aws.String(config.Bucket)}); err != nil {
+		t.Fatalf("The request should pass through the proxy: %v", err)
+	}
+
+	select {
+	case r := <-requests:
+		if r.Method != http.MethodHead || r.URL.Host != "s3.pandora.invalid" || r.URL.Path != "/pandora" {
+			t.Errorf("The proxy got %s %s, want HEAD http://s3.pandora.invalid/pandora", r.Method, r.URL)
+		}
+	default:
+		t.Error("The proxy got no request")
+	}
+}
+
+func TestRegionRedirect(t *testing.T) {
+	const permanentRedirect = `<Error><Code>PermanentRedirect</Code><Message>The bucket is in another region.</Message></Error>`
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantRegion string
+		wantStatus int
+	}{
+		{
+			name: "301 with the region header",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("x-amz-bucket-region", "eu-west-1")
+				w.WriteHeader(http.StatusMovedPermanently)
+				_, _ = w.Write([]byte(permanentRedirect))
+			},
+			wantRegion: "eu-west-1",
+		},
+		{
+			name: "301 with the region from HeadBucket",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.Header().Set("x-amz-bucket-region", "ap-east-1")
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.WriteHeader(http.StatusMovedPermanently)
+				_, _ = w.Write([]byte(permanentRedirect))
+			},
+			wantRegion: "ap-east-1",
+		},
+		{
+			// The SDK client never follows the redirects, the other redirects aren't the region mismatch.
+			name: "302 is returned as it is",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-amz-bucket-region", "eu-west-1")
+				http.Redirect(w, r, "/moved/pandora?"+r.URL.RawQuery, http.StatusFound)
+			},
+			wantStatus: http.StatusFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			config := testS3Config(server.URL)
+			bucket := &BucketClient{Client: newS3Client("s3", config, true), Bucket: config.Bucket}
+			_, err := bucket.ListObjects(t.Context(), "images/")
+
+			var redirect *RegionRedirectError
+			if tt.wantStatus != 0 {
+				var respErr *awshttp.ResponseError
+				if errors.As(err, &redirect) || !errors.As(err, &respErr) || respErr.HTTPStatusCode() != tt.wantStatus {
+					t.Fatalf("The error should be the %d response error, got %v", tt.wantStatus, err)
+				}
+				return
+			}
+			if !errors.As(err, &redirect) {
+				t.Fatalf("The error should be a RegionRedirectError, got %v", err)
+			}
+			if redirect.Region != tt.wantRegion {
+				t.Errorf("The bucket region is %s, want %s", redirect.Region, tt.wantRegion)
+			}
+		})
+	}
+}
+
+func TestObjectChanged(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pandora/images/cat.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(1024))
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := testS3Config(server.URL)
+	bucket := &BucketClient{Client: newS3Client("s3", config, true), Bucket: config.Bucket}
+	tests := []struct {
+		name        string
+		key         string
+		size        int64
+		mtime       time.Time
+		wantChanged bool
+		wantExists  bool
+	}{
+		{"unchanged", "images/cat.jpg", 1024, modified.Add(-time.Hour), false, true},
+		{"same second", "images/cat.jpg", 1024, modified.Add(500 * time.Millisecond), false, true},
+		{"local newer than remote", "images/cat.jpg", 1024, modified.Add(time.Second), true, true},
+		{"sizes differ", "images/cat.jpg", 2048, modified.Add(-time.Hour), true, true},
+		{"missing", "images/dog.jpg", 1024, modified, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, exists := bucket.ObjectChanged(t.Context(), tt.key, tt.size, tt.mtime)
+			if changed != tt.wantChanged || exists != tt.wantExists {
+				t.Errorf("ObjectChanged(%s) = %v, %v, want %v, %v", tt.key, changed, exists, tt.wantChanged, tt.wantExists)
+			}
+		})
+	}
+}
+
+func TestClaimKeys(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"images/Cat Photo.jpg", "images/cat-photo.jpg", "images/dog.jpg", "images/.hidden/Dog.jpg"} {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := listSyncFiles(root, []string{"images", "uploads"})
+	if err != nil || len(files) != 3 {
+		t.Fatalf("The hidden directory should be skipped, got %v, %v", files, err)
+	}
+
+	client := &BucketClient{}
+	claimedKeys = map[string]string{}
+	if err := claimKeys(client, root, files); err != nil {
+		t.Fatalf("The keys aren't slugified, no key collides: %v", err)
+	}
+
+	// The collision is always reported for the same files in the path order, no matter the listed order.
+	client.Slugify = true
+	reversed := []string{files[2], files[1], files[0]}
+	for _, list := range [][]string{files, reversed} {
+		claimedKeys = map[string]string{}
+		err := claimKeys(client, root, list)
+		want := "the files " + filepath.Join(root, "images", "Cat Photo.jpg") + " and " + filepath.Join(root, "images", "cat-photo.jpg")
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("The collision should name both files, got %v", err)
+		}
+	}
+	claimedKeys = map[string]string{}
+}
+
+func TestDetectPathStyle(t *testing.T) {
+	var probes atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		probes.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := testS3Config(server.URL)
+	config.UsePathStyle = nil
+	for i := 0; i < 3; i++ {
+		detectPathStyle("s3", config)
+	}
+	if probes.Load() != 1 {
+		t.Errorf("The endpoint should be probed once for the run, got %d requests", probes.Load())
+	}
+}
+
+// countingUploadClient records the max number of the concurrent part uploads.
+type countingUploadClient struct {
+	manager.UploadAPIClient
+	running, peak atomic.Int64
+}
+
+func (c *countingUploadClient) UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	running := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		peak := c.peak.Load()
+		if running <= peak || c.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return &s3.UploadPartOutput{}, nil
+}
+
+func TestPartLimitedClient(t *testing.T) {
+	original := partTokens
+	defer func() { partTokens = original }()
+	partTokens = make(chan struct{}, 3)
+
+	counting := &countingUploadClient{}
+	client := partLimitedClient{counting}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = client.UploadPart(t.Context(), &s3.UploadPartInput{})
+		}()
+	}
+	wg.Wait()
+	if peak := counting.peak.Load(); peak > 3 {
+		t.Errorf("The concurrent part uploads should be bounded by 3, got %d", peak)
+	}
+}
+
+func TestMetadataLookup(t *testing.T) {
+	index := newMetadataIndex([]ImageMetadata{
+		{Slug: "/images/a.jpg", Hash: "a", BlurFormat: BlurWebP},
+		{Slug: "/images/b.jpg", Hash: "b", BlurFormat: "webp,blurhash"},
+		{Slug: "/images/old.jpg", Hash: "old"},
+	})
+	tests := []struct {
+		name     string
+		slug     string
+		hash     string
+		format   string
+		wantSlug string
+	}{
+		{"unchanged", "/images/a.jpg", "a", BlurWebP, "/images/a.jpg"},
+		{"content changed", "/images/a.jpg", "c", BlurWebP, ""},
+		{"renamed", "/images/renamed.jpg", "a", BlurWebP, "/images/renamed.jpg"},
+		{"combined format", "/images/b.jpg", "b", "webp,blurhash", "/images/b.jpg"},
+		{"format changed", "/images/a.jpg", "a", BlurHashFormat, ""},
+		{"renamed in another format", "/images/renamed.jpg", "b", BlurWebP, ""},
+		{"without the hash and format", "/images/old.jpg", "old", BlurWebP, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slug := ""
+			if meta := index.Lookup(tt.slug, tt.hash, tt.format); meta != nil {
+				slug = meta.Slug
+			}
+			if slug != tt.wantSlug {
+				t.Errorf("Lookup(%s, %s, %s) reuses %q, want %q", tt.slug, tt.hash, tt.format, slug, tt.wantSlug)
+			}
+		})
+	}
+}
+
+func TestSyncFileMetadataKey(t *testing.T) {
+	requireVips(t)
+	root := t.TempDir()
+	filename := filepath.Join(root, "images", "2024", "a.jpg")
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, testJPEG(t, 64, 48, gradient), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { syncPlan = nil }()
+
+	// The planned upload returns the metadata without touching the bucket.
+	syncPlan = &SyncPlan{}
+	client := &BucketClient{Prefixes: map[string]string{"images": "assets"}}
+	meta := SyncFile(client, root, filename, map[string]RemoteObject{}, &SyncSummary{})
+	if meta == nil {
+		t.Fatal("The image metadata is missing")
+	}
+	if meta.Slug != "/images/2024/a.jpg" || meta.Key != "assets/2024/a.jpg" {
+		t.Errorf("The metadata has the slug %q and the key %q, want the local path and the mapped key", meta.Slug, meta.Key)
+	}
+}
+
+func TestMetadataObjectKey(t *testing.T) {
+	client := &BucketClient{Prefixes: map[string]string{"images": "assets"}}
+	tests := []struct {
+		name string
+		meta ImageMetadata
+		want string
+	}{
+		{"recorded key", ImageMetadata{Slug: "/images/a.jpg", Key: "2024/01/a.jpg"}, "2024/01/a.jpg"},
+		{"mapped from the slug", ImageMetadata{Slug: "/images/a.jpg"}, "assets/a.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.meta.objectKey(client); got != tt.want {
+				t.Errorf("The object key is %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
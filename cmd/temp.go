@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"os"
+	"runtime"
+)
+
+// setupTempDir points the temporary files at the tempDir in config, libvips spills the large decoded
+// images into it. The tempDir is created if it doesn't exist, the os.TempDir() is kept if it's empty.
+func setupTempDir(config *PandoraConfig) error {
+	if config.TempDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(config.TempDir, os.FileMode(0755)); err != nil {
+		return exitErrorf(ExitConfig, "Failed to create the tempDir %s in config file.\nError: %v", config.TempDir, err)
+	}
+	key := "TMPDIR"
+	if runtime.GOOS == "windows" {
+		key = "TMP"
+	}
+	if err := os.Setenv(key, config.TempDir); err != nil {
+		return exitErrorf(ExitFailure, "Failed to set the temp directory %s.\nError: %v", config.TempDir, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// thumbHashMaxSize is the max width and height of the pixels encoded into the ThumbHash,
+// the larger image is slow to encode with no benefit.
+const thumbHashMaxSize = 100
+
+// thumbHash encodes the image into the ThumbHash bytes, it follows the reference implementation
+// in https://github.com/evanw/thumbhash. The transparent pixels are composited atop the average color.
+func thumbHash(img image.Image) []byte {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rgba := make([]color.NRGBA, 0, w*h)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			rgba = append(rgba, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+
+	// Determine the average color.
+	var avgR, avgG, avgB, avgA float64
+	for _, c := range rgba {
+		alpha := float64(c.A) / 255
+		avgR += alpha / 255 * float64(c.R)
+		avgG += alpha / 255 * float64(c.G)
+		avgB += alpha / 255 * float64(c.B)
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR, avgG, avgB = avgR/avgA, avgG/avgA, avgB/avgA
+	}
+	hasAlpha := avgA < float64(w*h)
+	lLimit := 7.0
+	if hasAlpha {
+		// Use fewer luminance bits if there's alpha.
+		lLimit = 5
+	}
+	longest := float64(max(w, h))
+	lx := max(1, int(jsRound(lLimit*float64(w)/longest)))
+	ly := max(1, int(jsRound(lLimit*float64(h)/longest)))
+
+	// Convert the image from RGBA to LPQA, the luminance, yellow-blue, red-green and alpha.
+	l, p, q, a := make([]float64, w*h), make([]float64, w*h), make([]float64, w*h), make([]float64, w*h)
+	for i, c := range rgba {
+		alpha := float64(c.A) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(c.R)
+		g := avgG*(1-alpha) + alpha/255*float64(c.G)
+		b := avgB*(1-alpha) + alpha/255*float64(c.B)
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	// Encode using the DCT into the DC (constant) and the normalized AC (varying) terms.
+	encodeChannel := func(channel []float64, nx, ny int) (float64, []float64, float64) {
+		var dc, scale float64
+		var ac []float64
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				f := 0.0
+				for x := 0; x < w; x++ {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(w * h)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+	lDC, lAC, lScale := encodeChannel(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+	acs := [][]float64{lAC, pAC, qAC}
+	var aDC, aScale float64
+	if hasAlpha {
+		var aAC []float64
+		aDC, aAC, aScale = encodeChannel(a, 5, 5)
+		acs = append(acs, aAC)
+	}
+
+	// Write the constants.
+	isLandscape := w > h
+	header24 := int(jsRound(63*lDC)) | int(jsRound(31.5+31.5*pDC))<<6 | int(jsRound(31.5+31.5*qDC))<<12 | int(jsRound(31*lScale))<<18
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	header16 := int(jsRound(63*pScale))<<3 | int(jsRound(63*qScale))<<9
+	if isLandscape {
+		header16 |= ly | 1<<15
+	} else {
+		header16 |= lx
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	if hasAlpha {
+		hash = append(hash, byte(int(jsRound(15*aDC))|int(jsRound(15*aScale))<<4))
+	}
+
+	// Write the varying factors, two factors in every byte.
+	acStart, acIndex := len(hash), 0
+	for _, ac := range acs {
+		for _, f := range ac {
+			if acIndex&1 == 0 {
+				hash = append(hash, 0)
+			}
+			hash[acStart+acIndex>>1] |= byte(int(jsRound(15*f)) << ((acIndex & 1) << 2))
+			acIndex++
+		}
+	}
+	return hash
+}
+
+// jsRound rounds the half up like the Math.round of JavaScript in the reference implementation.
+func jsRound(v float64) float64 {
+	return math.Floor(v + 0.5)
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"sync"
+
+	"github.com/h2non/bimg"
+	"github.com/spf13/cobra"
+)
+
+const verifyConcurrency = 10
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the image dimensions in the metadata file against the uploaded images. Nothing will be changed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := ReadConfig()
+		if err != nil {
+			return err
+		}
+		if err := setupLogging(config); err != nil {
+			return err
+		}
+		if err := setupVips(config); err != nil {
+			return err
+		}
+		client, err := newBucketClient(config)
+		if err != nil {
+			return err
+		}
+
+		metas, err := DownloadMetadata(client, config)
+		if err != nil {
+			return exitErrorf(ExitFailure, "Failed to download the image metadata\nError: %v", err)
+		}
+		infof("Verify %d images in the metadata file", len(metas))
+
+		mismatches := VerifyMetadata(client, metas)
+		if mismatches > 0 {
+			return exitErrorf(ExitFailure, "Found %d mismatched images in the metadata file", mismatches)
+		}
+		successf("All the image metadata are matched")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
+
+// VerifyMetadata downloads the image for every metadata entry and compares the real dimensions
+// with the stored ones. It returns the count of the mismatched or unreadable entries.
+func VerifyMetadata(client *BucketClient, metas []ImageMetadata) int {
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		mismatches int
+	)
+	tokens := make(chan struct{}, verifyConcurrency)
+	for _, meta := range metas {
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func(meta ImageMetadata) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			ok := verifyImage(client, meta)
+			if !ok {
+				mu.Lock()
+				mismatches++
+				mu.Unlock()
+			}
+		}(meta)
+	}
+	wg.Wait()
+
+	return mismatches
+}
+
+func verifyImage(client *BucketClient, meta ImageMetadata) bool {
+	key := meta.objectKey(client)
+	content, err := client.DownloadObject(operationContext, key)
+	if err != nil {
+		errorf("Failed to download the image [%v]\nError: %v", key, err)
+		return false
+	}
+	size, err := bimg.NewImage(content).Size()
+	if err != nil {
+		errorf("Failed to read the image size for [%v]\nError: %v", key, err)
+		return false
+	}
+	if size.Width != meta.Width || size.Height != meta.Height {
+		errorf("Mismatched dimensions for [%v], metadata: %dx%d, actual: %dx%d",
+			key, meta.Width, meta.Height, size.Width, size.Height)
+		return false
+	}
+	return true
+}
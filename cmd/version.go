@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/h2non/bimg"
+	"github.com/spf13/cobra"
+)
+
+// The build metadata is set by the -ldflags at build time, like
+// -X github.com/syhily/pandora/cmd.Version=v1.0.0. The go run and go install builds keep the defaults,
+// the commit and its date are read from the embedded VCS info if it's available.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version, git commit and build date of pandora",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(versionInfo())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+	rootCmd.Version = Version
+	rootCmd.SetVersionTemplate(`{{printf "%s" (versionInfo)}}`)
+	cobra.AddTemplateFunc("versionInfo", versionInfo)
+}
+
+// versionInfo formats the build metadata with the Go runtime and the linked libvips.
+func versionInfo() string {
+	commit, date := Commit, BuildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && commit == "unknown":
+				commit = setting.Value
+			case setting.Key == "vcs.time" && date == "unknown":
+				date = setting.Value
+			}
+		}
+	}
+	return fmt.Sprintf("pandora %s\ncommit: %s\ndate: %s\ngo: %s %s/%s\nlibvips: %s\n",
+		Version, commit, date, runtime.Version(), runtime.GOOS, runtime.GOARCH, bimg.VipsVersion)
+}
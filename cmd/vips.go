@@ -0,0 +1,287 @@
+package cmd
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include "vips/vips.h"
+
+static int pandora_save(void *buf, size_t len, const char *suffix,
+	const char *copyright, const char *author, void **out, size_t *out_len) {
+	VipsImage *image, *copy;
+	int err;
+
+	image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+	err = vips_copy(image, &copy, NULL);
+	g_object_unref(image);
+	if (err) {
+		return err;
+	}
+	if (copyright[0]) {
+		vips_image_set_string(copy, "exif-ifd0-Copyright", copyright);
+	}
+	if (author[0]) {
+		vips_image_set_string(copy, "exif-ifd0-Artist", author);
+	}
+	err = vips_image_write_to_buffer(copy, suffix, out, out_len, NULL);
+	g_object_unref(copy);
+	return err;
+}
+
+static int pandora_autorot(void *buf, size_t len, void **out, size_t *out_len) {
+	VipsImage *image, *rotated;
+	int err;
+
+	image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+	err = vips_autorot(image, &rotated, NULL);
+	g_object_unref(image);
+	if (err) {
+		return err;
+	}
+	err = vips_image_write_to_buffer(rotated, ".png", out, out_len, NULL);
+	g_object_unref(rotated);
+	return err;
+}
+
+static int pandora_text(const char *text, const char *font, double *color, void **out, size_t *out_len) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+	double ones[3] = { 1, 1, 1 };
+	int err;
+
+	// The text mask is the alpha of the image filled in the color.
+	err = vips_text(&t[0], text, "font", font, "dpi", 72, NULL) ||
+		vips_black(&t[1], t[0]->Xsize, t[0]->Ysize, "bands", 3, NULL) ||
+		vips_linear(t[1], &t[2], ones, color, 3, NULL) ||
+		vips_cast(t[2], &t[3], VIPS_FORMAT_UCHAR, NULL) ||
+		vips_bandjoin2(t[3], t[0], &t[4], NULL) ||
+		vips_copy(t[4], &t[5], "interpretation", VIPS_INTERPRETATION_sRGB, NULL) ||
+		vips_image_write_to_buffer(t[5], ".png", out, out_len, NULL);
+	g_object_unref(base);
+	return err;
+}
+
+static int pandora_arrayjoin(void *buf, size_t *lens, int n, int across, int cell, int padding,
+	double *background, void **out, size_t *out_len) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4 * n + 2);
+	VipsImage **cells = g_new(VipsImage *, n);
+	VipsArrayDouble *ink = vips_array_double_new(background, 3);
+	char *p = buf;
+	int i, err = 0;
+
+	// Every image is flattened on the background and centered in the square cell.
+	for (i = 0; i < n && !err; i++) {
+		VipsImage **s = t + 4 * i;
+		s[0] = vips_image_new_from_buffer(p, lens[i], "", NULL);
+		p += lens[i];
+		err = s[0] == NULL ||
+			vips_colourspace(s[0], &s[1], VIPS_INTERPRETATION_sRGB, NULL) ||
+			(vips_image_hasalpha(s[1]) ?
+				vips_flatten(s[1], &s[2], "background", ink, NULL) :
+				vips_copy(s[1], &s[2], NULL)) ||
+			vips_gravity(s[2], &s[3], VIPS_COMPASS_DIRECTION_CENTRE, cell, cell,
+				"extend", VIPS_EXTEND_BACKGROUND, "background", ink, NULL);
+		cells[i] = s[3];
+	}
+	// The last row is filled by the background, the border is as wide as the shim between the cells.
+	err = err ||
+		vips_arrayjoin(cells, &t[4 * n], n, "across", across, "shim", padding, "background", ink, NULL) ||
+		vips_embed(t[4 * n], &t[4 * n + 1], padding, padding,
+			t[4 * n]->Xsize + 2 * padding, t[4 * n]->Ysize + 2 * padding,
+			"extend", VIPS_EXTEND_BACKGROUND, "background", ink, NULL) ||
+		vips_image_write_to_buffer(t[4 * n + 1], ".png", out, out_len, NULL);
+	vips_area_unref(VIPS_AREA(ink));
+	g_free(cells);
+	g_object_unref(base);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"unsafe"
+
+	"github.com/h2non/bimg"
+)
+
+// setupVips tunes the libvips operation cache and thread pool for large batches.
+// The zero values keep the bimg defaults: 1 thread, 500 operations and 100MB cache memory.
+func setupVips(config *PandoraConfig) error {
+	if err := setupTempDir(config); err != nil {
+		return err
+	}
+	if config.Vips.Concurrency < 0 || config.Vips.CacheMax < 0 || config.Vips.CacheMaxMem < 0 {
+		return exitErrorf(ExitConfig, "Invalid vips settings in config file, they should be positive numbers")
+	}
+	if config.Vips.Concurrency > 0 {
+		C.vips_concurrency_set(C.int(config.Vips.Concurrency))
+	}
+	if config.Vips.CacheMax > 0 {
+		bimg.VipsCacheSetMax(config.Vips.CacheMax)
+	}
+	if config.Vips.CacheMaxMem > 0 {
+		bimg.VipsCacheSetMaxMem(config.Vips.CacheMaxMem)
+	}
+	return nil
+}
+
+// vipsSaveOptions are the save options which aren't supported by bimg.
+type vipsSaveOptions struct {
+	// The copyright and author written into the EXIF IFD0 fields.
+	Copyright string
+	Author    string
+	// Disable the 4:2:0 chroma subsampling of the JPEG output, which blurs the colored text.
+	NoSubsample bool
+	// Save the PNG in the max compression, and quantize it into a palette if the colors isn't 0.
+	OptimizePNG bool
+	PNGColors   int
+	// Save the progressive JPEG or the interlaced PNG.
+	Interlace bool
+}
+
+// paletteBitDepth returns the smallest PNG bit depth which holds the colors, libvips quantizes by the bit depth.
+func paletteBitDepth(colors int) int {
+	for _, depth := range []int{1, 2, 4} {
+		if colors <= 1<<depth {
+			return depth
+		}
+	}
+	return 8
+}
+
+// vipsSave saves the image in the given format by libvips with the options unsupported by bimg.
+// The lossy formats are encoded in the given quality, the source should be lossless for avoiding the loss.
+func vipsSave(buf []byte, format string, quality int, options vipsSaveOptions) ([]byte, error) {
+	var suffix string
+	switch t := imageType(format); t {
+	case bimg.JPEG:
+		params := []string{fmt.Sprintf("Q=%d", quality)}
+		if options.NoSubsample {
+			params = append(params, "subsample-mode=off")
+		}
+		if options.Interlace {
+			params = append(params, "interlace=true")
+		}
+		suffix = ".jpeg[" + strings.Join(params, ",") + "]"
+	case bimg.WEBP, bimg.AVIF:
+		suffix = fmt.Sprintf(".%s[Q=%d]", bimg.ImageTypeName(t), quality)
+	case bimg.PNG:
+		var params []string
+		if options.OptimizePNG {
+			params = append(params, "compression=9")
+		}
+		if options.OptimizePNG && options.PNGColors > 0 {
+			params = append(params, "palette=true", fmt.Sprintf("bitdepth=%d", paletteBitDepth(options.PNGColors)))
+		}
+		if options.Interlace {
+			params = append(params, "interlace=true")
+		}
+		suffix = ".png"
+		if len(params) > 0 {
+			suffix += "[" + strings.Join(params, ",") + "]"
+		}
+	default:
+		return nil, fmt.Errorf("the %s format couldn't be saved by libvips", format)
+	}
+
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("the image is empty")
+	}
+	cSuffix, cCopyright, cAuthor := C.CString(suffix), C.CString(options.Copyright), C.CString(options.Author)
+	defer C.free(unsafe.Pointer(cSuffix))
+	defer C.free(unsafe.Pointer(cCopyright))
+	defer C.free(unsafe.Pointer(cAuthor))
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.pandora_save(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), cSuffix, cCopyright, cAuthor, &out, &length) != 0 {
+		return nil, vipsError("libvips failed to save the image in %s", suffix)
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(length)), nil
+}
+
+// vipsAutoOrient rotates and flips the pixels by the EXIF orientation, the orientation tag is removed.
+// The image is returned in the lossless PNG for avoiding the generational loss in the later encoding.
+func vipsAutoOrient(buf []byte) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("the image is empty")
+	}
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.pandora_autorot(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &out, &length) != 0 {
+		return nil, vipsError("libvips failed to rotate the image by the EXIF orientation")
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(length)), nil
+}
+
+// vipsText renders the text in the font like "sans 24" into a transparent PNG filled in the color.
+func vipsText(text, font string, c color.RGBA) ([]byte, error) {
+	if text == "" {
+		return nil, fmt.Errorf("the text is empty")
+	}
+	cText, cFont := C.CString(text), C.CString(font)
+	defer C.free(unsafe.Pointer(cText))
+	defer C.free(unsafe.Pointer(cFont))
+	rgb := []C.double{C.double(c.R), C.double(c.G), C.double(c.B)}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.pandora_text(cText, cFont, &rgb[0], &out, &length) != 0 {
+		return nil, vipsError("libvips failed to render the text %q", text)
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(length)), nil
+}
+
+// vipsArrayJoin centers the images in the square cells and joins them into the grid of the columns by libvips.
+// The cells and the border are spaced by the padding in the background color, the grid is returned in PNG.
+func vipsArrayJoin(images [][]byte, columns, cell, padding int, background color.RGBA) ([]byte, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image to join")
+	}
+	// The images are passed in one buffer, the Go memory given to C couldn't hold the Go pointers.
+	var buf []byte
+	lens := make([]C.size_t, len(images))
+	for i, image := range images {
+		if len(image) == 0 {
+			return nil, fmt.Errorf("the image %d is empty", i)
+		}
+		buf = append(buf, image...)
+		lens[i] = C.size_t(len(image))
+	}
+	rgb := []C.double{C.double(background.R), C.double(background.G), C.double(background.B)}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.pandora_arrayjoin(unsafe.Pointer(&buf[0]), &lens[0], C.int(len(images)), C.int(min(columns, len(images))),
+		C.int(cell), C.int(padding), &rgb[0], &out, &length) != 0 {
+		return nil, vipsError("libvips failed to join the %d images", len(images))
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(length)), nil
+}
+
+// vipsError creates the error with the message in the libvips error buffer, the buffer is cleared.
+func vipsError(format string, args ...any) error {
+	message := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+	C.vips_error_clear()
+	if message == "" {
+		return fmt.Errorf(format, args...)
+	}
+	return fmt.Errorf(format+": %s", append(args, message)...)
+}
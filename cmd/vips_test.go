@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestVipsEmptyInput(t *testing.T) {
+	if _, err := vipsSave(nil, JPG, 80, vipsSaveOptions{}); err == nil {
+		t.Error("Saving the empty image should fail")
+	}
+	if _, err := vipsAutoOrient([]byte{}); err == nil {
+		t.Error("Rotating the empty image should fail")
+	}
+	if _, err := vipsText("", "sans 24", color.RGBA{A: 255}); err == nil {
+		t.Error("Rendering the empty text should fail")
+	}
+	if _, err := vipsArrayJoin(nil, 4, 320, 8, color.RGBA{A: 255}); err == nil {
+		t.Error("Joining no image should fail")
+	}
+	if _, err := vipsArrayJoin([][]byte{{}}, 4, 320, 8, color.RGBA{A: 255}); err == nil {
+		t.Error("Joining the empty image should fail")
+	}
+}
+
+func TestVipsError(t *testing.T) {
+	// The garbage isn't decoded by libvips, the reason is read from the libvips error buffer.
+	_, err := vipsSave([]byte("not an image"), JPG, 80, vipsSaveOptions{})
+	if err == nil {
+		t.Fatal("Saving the garbage should fail")
+	}
+	prefix := "libvips failed to save the image in .jpeg[Q=80]: "
+	if !strings.HasPrefix(err.Error(), prefix) || len(err.Error()) == len(prefix) {
+		t.Errorf("The error should have the libvips error buffer, got %q", err)
+	}
+}
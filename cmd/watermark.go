@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	WatermarkTopLeft     = "top-left"
+	WatermarkTopRight    = "top-right"
+	WatermarkBottomLeft  = "bottom-left"
+	WatermarkBottomRight = "bottom-right"
+	WatermarkCenter      = "center"
+)
+
+var watermarkPositions = map[string]struct{}{
+	WatermarkTopLeft:     {},
+	WatermarkTopRight:    {},
+	WatermarkBottomLeft:  {},
+	WatermarkBottomRight: {},
+	WatermarkCenter:      {},
+}
+
+var (
+	watermarkText     = ""
+	watermarkImage    = ""
+	watermarkPosition = WatermarkBottomRight
+	watermarkOpacity  = 0.5
+	watermarkFontSize = 0
+	watermarkColor    = "#ffffff"
+	// watermarkLogo is the content of the --watermark-image, it's loaded once for all the images.
+	watermarkLogo []byte
+)
+
+func init() {
+	imageCmd.Flags().StringVarP(&watermarkText, "watermark-text", "", "", "Draw the text watermark on the converted image")
+	imageCmd.Flags().StringVarP(&watermarkImage, "watermark-image", "", "", "Draw the logo image like a transparent png as the watermark, it's scaled down within a quarter of the image width")
+	imageCmd.Flags().StringVarP(&watermarkPosition, "watermark-position", "", WatermarkBottomRight, "The watermark position, one of "+supportedWatermarkPositions())
+	imageCmd.Flags().Float64VarP(&watermarkOpacity, "watermark-opacity", "", 0.5, "The watermark opacity from 0 to 1")
+	imageCmd.Flags().IntVarP(&watermarkFontSize, "watermark-font-size", "", 0, "The font size of the --watermark-text in pixels, 0 for a 40th of the image width")
+	imageCmd.Flags().StringVarP(&watermarkColor, "watermark-color", "", "#ffffff", "The color of the --watermark-text in hex format")
+}
+
+func supportedWatermarkPositions() string {
+	positions := make([]string, 0, len(watermarkPositions))
+	for position := range watermarkPositions {
+		positions = append(positions, position)
+	}
+	sort.Strings(positions)
+	return strings.Join(positions, ", ")
+}
+
+// hasWatermark returns true if any watermark is drawn, the image isn't changed without the watermark flags.
+func hasWatermark() bool {
+	return watermarkText != "" || watermarkImage != ""
+}
+
+// loadWatermark validates the watermark flags and loads the --watermark-image.
+func loadWatermark() error {
+	if watermarkText != "" && watermarkImage != "" {
+		return fmt.Errorf("the --watermark-text and --watermark-image couldn't be used together")
+	}
+	if _, ok := watermarkPositions[watermarkPosition]; !ok {
+		return fmt.Errorf("invalid watermark position %s, only supports %s", watermarkPosition, supportedWatermarkPositions())
+	}
+	if watermarkOpacity <= 0 || watermarkOpacity > 1 {
+		return fmt.Errorf("invalid watermark opacity %v, it should be between 0 and 1", watermarkOpacity)
+	}
+	if watermarkFontSize < 0 {
+		return fmt.Errorf("invalid watermark font size %d, it should be a positive number", watermarkFontSize)
+	}
+	if _, err := parseHexColor(watermarkColor); err != nil {
+		return fmt.Errorf("invalid watermark color %s: %v", watermarkColor, err)
+	}
+	if watermarkImage == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(watermarkImage)
+	if err != nil {
+		return err
+	}
+	if _, err := bimg.NewImage(content).Size(); err != nil {
+		return fmt.Errorf("the watermark image %s is invalid: %v", watermarkImage, err)
+	}
+	watermarkLogo = content
+	return nil
+}
+
+// watermarkOptions renders the watermark for the image in the given size. The text is rendered into
+// a transparent image, bimg draws its own text watermark at a fixed offset.
+func watermarkOptions(width, height int) (bimg.WatermarkImage, error) {
+	if !hasWatermark() {
+		return bimg.WatermarkImage{}, nil
+	}
+
+	var mark []byte
+	var err error
+	if watermarkText != "" {
+		size := watermarkFontSize
+		if size == 0 {
+			size = max(12, width/40)
+		}
+		c, _ := parseHexColor(watermarkColor)
+		mark, err = vipsText(watermarkText, fmt.Sprintf("sans %d", size), c)
+	} else {
+		mark, err = scaleLogo(watermarkLogo, max(1, width/4))
+	}
+	if err != nil {
+		return bimg.WatermarkImage{}, err
+	}
+	markSize, err := bimg.NewImage(mark).Size()
+	if err != nil {
+		return bimg.WatermarkImage{}, err
+	}
+
+	left, top := watermarkOffset(watermarkPosition, width, height, markSize.Width, markSize.Height, max(1, width/50))
+	return bimg.WatermarkImage{Left: left, Top: top, Buf: mark, Opacity: float32(watermarkOpacity)}, nil
+}
+
+// scaleLogo scales the logo down into the max width, the small logo is kept as it is.
+func scaleLogo(logo []byte, maxWidth int) ([]byte, error) {
+	size, err := bimg.NewImage(logo).Size()
+	if err != nil {
+		return nil, err
+	}
+	if size.Width <= maxWidth {
+		return logo, nil
+	}
+	return bimg.NewImage(logo).Process(bimg.Options{
+		Width:  maxWidth,
+		Height: max(1, size.Height*maxWidth/size.Width),
+		Type:   bimg.PNG,
+	})
+}
+
+// watermarkOffset computes the top left corner of the watermark at the position, the corners keep the margin.
+func watermarkOffset(position string, width, height, markWidth, markHeight, margin int) (left, top int) {
+	switch position {
+	case WatermarkTopLeft:
+		left, top = margin, margin
+	case WatermarkTopRight:
+		left, top = width-markWidth-margin, margin
+	case WatermarkBottomLeft:
+		left, top = margin, height-markHeight-margin
+	case WatermarkCenter:
+		left, top = (width-markWidth)/2, (height-markHeight)/2
+	default:
+		left, top = width-markWidth-margin, height-markHeight-margin
+	}
+	return max(0, left), max(0, top)
+}